@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/onee-only/netrat/pkg/client"
+)
+
+func runListen(ctx context.Context, socketAddr string, args []string) error {
+	var (
+		opts client.WorkerOptions
+
+		deviceList, layerList, asmList string
+		asJSON                         bool
+	)
+
+	fs := flag.NewFlagSet("listen", flag.ExitOnError)
+	fs.StringVar(&deviceList, "device", "", "comma separated devices to capture from")
+	fs.StringVar(&opts.PcapFile, "pcap", "", "pcap file to read from")
+	fs.StringVar(&opts.BPFFilter, "filter", "", "BPF filter expression")
+	snaplen := fs.Int("snaplen", 0, "snapshot length (0 for daemon default)")
+	fs.BoolVar(&opts.Promiscuous, "promisc", false, "put device into promiscuous mode")
+	fs.DurationVar(&opts.Timeout, "timeout", 0, "stop capturing after given duration")
+	fs.Uint64Var(&opts.MaxPackets, "max-packets", 0, "stop capturing after given number of packets")
+	fs.Uint64Var(&opts.MaxBytes, "max-bytes", 0, "stop capturing before given number of bytes is exceeded")
+	fs.Uint64Var(&opts.MaxStorageBytes, "max-storage", 0, "stop capturing once the capture database and pcapng files reach given size in bytes")
+	speed := fs.String("speed", "max", "replay speed of pcap file, multiplier of recorded speed or max")
+	fs.StringVar((*string)(&opts.Backend), "backend", "pcap", "capture backend for devices (pcap, afpacket)")
+	fs.Uint64Var(&opts.AFPacket.RingSize, "ring-size", 0, "afpacket ring buffer size in bytes per socket")
+	fs.DurationVar(&opts.AFPacket.BlockTimeout, "block-timeout", 0, "afpacket block timeout")
+	fanoutGroup := fs.Uint("fanout-group", 0, "afpacket fanout group id")
+	fs.StringVar(&opts.AFPacket.FanoutType, "fanout-type", "", "afpacket fanout type (hash, lb, cpu, rollover, random, qm)")
+	fs.IntVar(&opts.AFPacket.Sockets, "sockets", 1, "afpacket sockets per device, requires fanout group")
+	fs.IntVar(&opts.BufferSize, "buffer", 0, "number of packets buffered before storing (0 for daemon default)")
+	fs.StringVar((*string)(&opts.DropPolicy), "drop", "block", "what to do when the buffer is full (block, drop-newest, drop-oldest)")
+	fs.BoolVar(&opts.Pcapng.Enabled, "pcapng", false, "keep raw packets in pcapng files")
+	fs.Uint64Var(&opts.Pcapng.RotateSize, "rotate-size", 0, "start new pcapng file after given size in bytes")
+	fs.DurationVar(&opts.Pcapng.RotateInterval, "rotate-interval", 0, "start new pcapng file after given duration")
+	fs.IntVar(&opts.Pcapng.MaxFiles, "max-files", 0, "number of pcapng files to keep (0 keeps all)")
+	fs.StringVar(&layerList, "layers", "", "comma separated capture layers ("+layerNames()+")")
+	fs.StringVar(&asmList, "assemble", "", "comma separated assemble types (http)")
+	fs.BoolVar(&asJSON, "json", false, "print result as JSON")
+	fs.Parse(args)
+
+	opts.SnapLen = int32(*snaplen)
+	if *speed != "max" {
+		s, err := strconv.ParseFloat(strings.TrimSuffix(*speed, "x"), 64)
+		if err != nil || s <= 0 {
+			return fmt.Errorf("invalid replay speed %q", *speed)
+		}
+		opts.ReplaySpeed = s
+	}
+	if *fanoutGroup > 0xffff {
+		return fmt.Errorf("invalid fanout group %d", *fanoutGroup)
+	}
+	opts.AFPacket.FanoutGroup = uint16(*fanoutGroup)
+	opts.Devices = splitList(deviceList)
+
+	var err error
+	if opts.CaptureLayers, err = parseLayers(layerList); err != nil {
+		return err
+	}
+	if opts.AssembleTypes, err = parseAssembles(asmList); err != nil {
+		return err
+	}
+
+	c, err := client.Dial(ctx, socketAddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	id, err := c.Listen(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return printJSON(os.Stdout, map[string]string{"id": id.String()})
+	}
+
+	fmt.Println(id)
+	return nil
+}
+
+func runList(ctx context.Context, socketAddr string, args []string) error {
+	var asJSON bool
+
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.BoolVar(&asJSON, "json", false, "print result as JSON")
+	fs.Parse(args)
+
+	c, err := client.Dial(ctx, socketAddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	workers, err := c.Workers(ctx)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return printJSON(os.Stdout, toWorkerViews(workers))
+	}
+
+	return printWorkerTable(os.Stdout, workers)
+}
+
+func runStat(ctx context.Context, socketAddr string, args []string) error {
+	var asJSON bool
+
+	fs := flag.NewFlagSet("stat", flag.ExitOnError)
+	fs.BoolVar(&asJSON, "json", false, "print result as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: netrat stat [flags] <worker id>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	id, err := parseWorkerID(fs)
+	if err != nil {
+		return err
+	}
+
+	c, err := client.Dial(ctx, socketAddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	stat, err := c.Stat(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return printJSON(os.Stdout, toWorkerView(stat))
+	}
+
+	return printWorkerStat(os.Stdout, stat)
+}
+
+func runDevices(ctx context.Context, socketAddr string, args []string) error {
+	var asJSON bool
+
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	fs.BoolVar(&asJSON, "json", false, "print result as JSON")
+	fs.Parse(args)
+
+	c, err := client.Dial(ctx, socketAddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	devices, err := c.Devices(ctx)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return printJSON(os.Stdout, toDeviceViews(devices))
+	}
+
+	return printDeviceTable(os.Stdout, devices)
+}
+
+func runCancel(ctx context.Context, socketAddr string, args []string) error {
+	return workerAction(ctx, socketAddr, "cancel", args, (*client.Client).Cancel)
+}
+
+func runPause(ctx context.Context, socketAddr string, args []string) error {
+	return workerAction(ctx, socketAddr, "pause", args, (*client.Client).Pause)
+}
+
+func runResume(ctx context.Context, socketAddr string, args []string) error {
+	return workerAction(ctx, socketAddr, "resume", args, (*client.Client).Resume)
+}
+
+func runDelete(ctx context.Context, socketAddr string, args []string) error {
+	var keepData bool
+
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	fs.BoolVar(&keepData, "keep", false, "keep captured data on disk")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: netrat delete [flags] <worker id>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	id, err := parseWorkerID(fs)
+	if err != nil {
+		return err
+	}
+
+	c, err := client.Dial(ctx, socketAddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return c.Delete(ctx, id, keepData)
+}
+
+// workerAction runs a command that takes a worker id as its only argument.
+func workerAction(
+	ctx context.Context, socketAddr, name string, args []string,
+	action func(c *client.Client, ctx context.Context, id uuid.UUID) error,
+) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: netrat %s <worker id>\n", name)
+	}
+	fs.Parse(args)
+
+	id, err := parseWorkerID(fs)
+	if err != nil {
+		return err
+	}
+
+	c, err := client.Dial(ctx, socketAddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return action(c, ctx, id)
+}
+
+func parseWorkerID(fs *flag.FlagSet) (uuid.UUID, error) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return uuid.Nil, fmt.Errorf("%s: worker id required", fs.Name())
+	}
+
+	id, err := uuid.Parse(strings.TrimSpace(fs.Arg(0)))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: invalid worker id: %w", fs.Name(), err)
+	}
+	return id, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/uuid"
+	"github.com/onee-only/netrat/pkg/client"
+)
+
+func runExport(ctx context.Context, socketAddr string, args []string) error {
+	var (
+		opts client.ExportOptions
+
+		format, output                   string
+		start, end, src, dst, proto, sid string
+		layerList                        string
+		sport, dport                     uint
+	)
+
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&format, "format", "pcapng", "output format (pcap, pcapng)")
+	fs.StringVar(&output, "o", "", "write to file instead of stdout")
+	fs.StringVar(&opts.Path, "server-path", "", "make netratd write to given path in its export directory")
+	fs.StringVar(&start, "start", "", "export packets captured at or after given RFC 3339 time")
+	fs.StringVar(&end, "end", "", "export packets captured before given RFC 3339 time")
+	fs.StringVar(&src, "src", "", "source ip")
+	fs.StringVar(&dst, "dst", "", "destination ip")
+	fs.UintVar(&sport, "sport", 0, "source port")
+	fs.UintVar(&dport, "dport", 0, "destination port")
+	fs.StringVar(&proto, "proto", "", "transport protocol (tcp, udp)")
+	fs.StringVar(&layerList, "layers", "", "comma separated layers packets must have ("+layerNames()+")")
+	fs.StringVar(&sid, "http-stream", "", "http stream id")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: netrat export [flags] <worker id>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	id, err := parseWorkerID(fs)
+	if err != nil {
+		return err
+	}
+
+	opts.Format = client.ExportFormat(format)
+	if opts.Filter, err = parseExportFilter(start, end, src, dst, proto, sid, layerList); err != nil {
+		return err
+	}
+	if sport > 0xffff || dport > 0xffff {
+		return fmt.Errorf("invalid port")
+	}
+	opts.Filter.SrcPort, opts.Filter.DstPort = uint16(sport), uint16(dport)
+
+	var w io.Writer = os.Stdout
+	if output != "" && opts.Path == "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	c, err := client.Dial(ctx, socketAddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	packets, err := c.Export(ctx, id, opts, w)
+	if err != nil {
+		if output != "" && opts.Path == "" {
+			os.Remove(output)
+		}
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d packets\n", packets)
+	return nil
+}
+
+func parseExportFilter(start, end, src, dst, proto, sid, layerList string) (f client.ExportFilter, err error) {
+	for _, t := range []struct {
+		s string
+		t *time.Time
+	}{{start, &f.Start}, {end, &f.End}} {
+		if t.s == "" {
+			continue
+		}
+		if *t.t, err = time.Parse(time.RFC3339Nano, t.s); err != nil {
+			return f, fmt.Errorf("invalid time %q", t.s)
+		}
+	}
+
+	for _, ip := range []struct {
+		s  string
+		ip *net.IP
+	}{{src, &f.SrcIP}, {dst, &f.DstIP}} {
+		if ip.s == "" {
+			continue
+		}
+		if *ip.ip = net.ParseIP(ip.s); *ip.ip == nil {
+			return f, fmt.Errorf("invalid ip %q", ip.s)
+		}
+	}
+
+	switch proto {
+	case "":
+	case "tcp":
+		f.Transport = layers.LayerTypeTCP
+	case "udp":
+		f.Transport = layers.LayerTypeUDP
+	default:
+		return f, fmt.Errorf("unknown protocol %q", proto)
+	}
+
+	if sid != "" {
+		if f.HTTPStreamID, err = uuid.Parse(sid); err != nil {
+			return f, fmt.Errorf("invalid http stream id %q", sid)
+		}
+	}
+
+	var types []gopacket.LayerType
+	if types, err = parseLayers(layerList); err != nil {
+		return f, err
+	}
+	f.Layers = types
+
+	return f, nil
+}
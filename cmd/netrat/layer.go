@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/onee-only/netrat/pkg/assemble"
+)
+
+// captureLayers is the list of layers netratd knows how to store.
+var captureLayers = []gopacket.LayerType{
+	layers.LayerTypeEthernet,
+	layers.LayerTypeARP,
+	layers.LayerTypeIPv4,
+	layers.LayerTypeIPv6,
+	layers.LayerTypeTCP,
+	layers.LayerTypeUDP,
+	layers.LayerTypeICMPv4,
+	layers.LayerTypeICMPv6,
+	layers.LayerTypeDNS,
+}
+
+func parseLayers(s string) ([]gopacket.LayerType, error) {
+	var types []gopacket.LayerType
+	for _, name := range splitList(s) {
+		t, ok := lookupLayer(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown capture layer %q", name)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+func lookupLayer(name string) (gopacket.LayerType, bool) {
+	for _, t := range captureLayers {
+		if strings.EqualFold(t.String(), name) {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+func parseAssembles(s string) ([]assemble.AssembleType, error) {
+	var types []assemble.AssembleType
+	for _, name := range splitList(s) {
+		t := assemble.AssembleType(strings.ToLower(name))
+		if !t.Valid() {
+			return nil, fmt.Errorf("unknown assemble type %q", name)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+func layerNames() string {
+	names := make([]string, len(captureLayers))
+	for i, t := range captureLayers {
+		names[i] = strings.ToLower(t.String())
+	}
+	return strings.Join(names, ",")
+}
+
+func splitList(s string) (list []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
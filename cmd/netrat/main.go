@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/onee-only/netrat/internal/config"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, socketAddr string, args []string) error
+}
+
+var commands = map[string]command{
+	"listen": {usage: "start a new capture worker", run: runListen},
+	"list":   {usage: "list all workers", run: runList},
+	"stat":   {usage: "show stats of a worker", run: runStat},
+	"cancel": {usage: "stop a running worker", run: runCancel},
+	"pause":  {usage: "stop storing packets of a running worker", run: runPause},
+	"resume": {usage: "resume a paused worker", run: runResume},
+	"delete": {usage: "remove a worker and its captured data", run: runDelete},
+
+	"export":  {usage: "export packets of a worker as pcap or pcapng", run: runExport},
+	"devices": {usage: "list devices available for capturing", run: runDevices},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: netrat [flags] <command> [command flags]\n\ncommands:\n")
+	for _, name := range []string{"listen", "list", "stat", "cancel", "pause", "resume", "delete", "export", "devices"} {
+		fmt.Fprintf(out, "  %-8s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(out, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	socketAddr := flag.String("socket", config.DefaultServerAddr, "netratd socket address")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "netrat: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := cmd.run(ctx, *socketAddr, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "netrat: %s\n", err)
+		os.Exit(1)
+	}
+}
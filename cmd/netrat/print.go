@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/onee-only/netrat/pkg/assemble"
+	"github.com/onee-only/netrat/pkg/device"
+	"github.com/onee-only/netrat/pkg/stat"
+)
+
+// workerView is a human friendly representation of stat.Worker.
+type workerView struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+
+	Live       bool   `json:"live"`
+	Src        string `json:"src"`
+	Backend    string `json:"backend,omitempty"`
+	DropPolicy string `json:"drop_policy,omitempty"`
+
+	SnapLen     int32  `json:"snaplen"`
+	Promiscuous bool   `json:"promiscuous"`
+	BPFFilter   string `json:"bpf_filter,omitempty"`
+
+	Captures  []string `json:"captures"`
+	Assembles []string `json:"assembles"`
+
+	CreatedAt  time.Time     `json:"created_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Timeout    time.Duration `json:"timeout,omitempty"`
+	LastError  string        `json:"last_error,omitempty"`
+
+	MaxPackets      uint64 `json:"max_packets,omitempty"`
+	MaxBytes        uint64 `json:"max_bytes,omitempty"`
+	MaxStorageBytes uint64 `json:"max_storage_bytes,omitempty"`
+	StopReason      string `json:"stop_reason,omitempty"`
+
+	Received  uint64            `json:"received"`
+	Bytes     uint64            `json:"bytes"`
+	Discarded uint64            `json:"discarded"`
+	Stored    map[string]uint64 `json:"stored"`
+	Assembled map[string]uint64 `json:"assembled"`
+
+	KernelDropped uint64 `json:"kernel_dropped"`
+	IfDropped     uint64 `json:"if_dropped"`
+	UserDropped   uint64 `json:"user_dropped"`
+
+	Interfaces map[string]interfaceView `json:"interfaces,omitempty"`
+
+	FileRead uint64        `json:"file_read,omitempty"`
+	FileSize uint64        `json:"file_size,omitempty"`
+	ETA      time.Duration `json:"eta,omitempty"`
+}
+
+type interfaceView struct {
+	Received      uint64 `json:"received"`
+	Bytes         uint64 `json:"bytes"`
+	KernelDropped uint64 `json:"kernel_dropped"`
+	IfDropped     uint64 `json:"if_dropped"`
+	UserDropped   uint64 `json:"user_dropped"`
+}
+
+func toWorkerView(w stat.Worker) workerView {
+	v := workerView{
+		ID:          w.ID.String(),
+		State:       w.State.String(),
+		Live:        w.Live,
+		Src:         w.Src,
+		Backend:     w.Backend,
+		DropPolicy:  w.DropPolicy,
+		SnapLen:     w.SnapLen,
+		Promiscuous: w.Promiscuous,
+		BPFFilter:   w.BPFFilter,
+		Captures:    layerStrings(w.Captures),
+		Assembles:   assembleStrings(w.Assembles),
+		CreatedAt:   w.CreatedAt,
+		FinishedAt:  w.FinishedAt,
+		Timeout:     w.Timeout,
+		LastError:   w.LastError,
+		Received:    w.Received,
+		Bytes:       w.Bytes,
+		Discarded:   w.Discarded,
+		Stored:      make(map[string]uint64, len(w.Stored)),
+		Assembled:   make(map[string]uint64, len(w.Assembled)),
+
+		KernelDropped: w.KernelDropped,
+		IfDropped:     w.IfDropped,
+		UserDropped:   w.UserDropped,
+
+		MaxPackets:      w.MaxPackets,
+		MaxBytes:        w.MaxBytes,
+		MaxStorageBytes: w.MaxStorageBytes,
+
+		FileRead: w.FileRead,
+		FileSize: w.FileSize,
+		ETA:      w.ETA,
+	}
+
+	if w.StopReason != stat.StopReasonNone {
+		v.StopReason = w.StopReason.String()
+	}
+
+	for t, n := range w.Stored {
+		v.Stored[strings.ToLower(t.String())] = n
+	}
+	for t, n := range w.Assembled {
+		v.Assembled[string(t)] = n
+	}
+
+	if len(w.Interfaces) > 0 {
+		v.Interfaces = make(map[string]interfaceView, len(w.Interfaces))
+	}
+	for name, i := range w.Interfaces {
+		v.Interfaces[name] = interfaceView(i)
+	}
+
+	return v
+}
+
+func toWorkerViews(workers []stat.Worker) []workerView {
+	views := make([]workerView, len(workers))
+	for i, w := range workers {
+		views[i] = toWorkerView(w)
+	}
+	return views
+}
+
+type deviceView struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Flags       []string `json:"flags"`
+	Addresses   []string `json:"addresses"`
+	LinkTypes   []string `json:"link_types"`
+}
+
+func toDeviceViews(devices []device.Device) []deviceView {
+	views := make([]deviceView, len(devices))
+	for i, d := range devices {
+		v := deviceView{
+			Name:        d.Name,
+			Description: d.Description,
+			Flags:       make([]string, 0, 4),
+			Addresses:   make([]string, len(d.Addresses)),
+			LinkTypes:   make([]string, len(d.LinkTypes)),
+		}
+
+		for _, flag := range []struct {
+			set  bool
+			name string
+		}{
+			{d.Up, "up"}, {d.Running, "running"},
+			{d.Loopback, "loopback"}, {d.Wireless, "wireless"},
+		} {
+			if flag.set {
+				v.Flags = append(v.Flags, flag.name)
+			}
+		}
+
+		for j, addr := range d.Addresses {
+			if ones, bits := addr.Netmask.Size(); bits > 0 {
+				v.Addresses[j] = fmt.Sprintf("%s/%d", addr.IP, ones)
+			} else {
+				v.Addresses[j] = addr.IP.String()
+			}
+		}
+
+		for j, link := range d.LinkTypes {
+			v.LinkTypes[j] = link.Name
+		}
+
+		views[i] = v
+	}
+	return views
+}
+
+func printDeviceTable(w io.Writer, devices []device.Device) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tFLAGS\tADDRESSES\tLINK TYPES\tDESCRIPTION")
+	for _, v := range toDeviceViews(devices) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			v.Name, joinOrDash(v.Flags), joinOrDash(v.Addresses),
+			joinOrDash(v.LinkTypes), orDash(v.Description),
+		)
+	}
+	return tw.Flush()
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printWorkerTable(w io.Writer, workers []stat.Worker) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tSOURCE\tCAPTURES\tASSEMBLES\tCREATED")
+	for _, worker := range workers {
+		v := toWorkerView(worker)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			v.ID, v.State, source(worker),
+			joinOrDash(v.Captures), joinOrDash(v.Assembles),
+			formatTime(v.CreatedAt),
+		)
+	}
+	return tw.Flush()
+}
+
+func printWorkerStat(w io.Writer, worker stat.Worker) error {
+	v := toWorkerView(worker)
+
+	timeout := "-"
+	if v.Timeout > 0 {
+		timeout = v.Timeout.String()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%s\n", v.ID)
+	fmt.Fprintf(tw, "State:\t%s\n", v.State)
+	fmt.Fprintf(tw, "Source:\t%s\n", source(worker))
+	fmt.Fprintf(tw, "Backend:\t%s\n", orDash(v.Backend))
+	fmt.Fprintf(tw, "Drop policy:\t%s\n", orDash(v.DropPolicy))
+	fmt.Fprintf(tw, "SnapLen:\t%d\n", v.SnapLen)
+	fmt.Fprintf(tw, "Promiscuous:\t%t\n", v.Promiscuous)
+	fmt.Fprintf(tw, "BPF filter:\t%s\n", orDash(v.BPFFilter))
+	fmt.Fprintf(tw, "Captures:\t%s\n", joinOrDash(v.Captures))
+	fmt.Fprintf(tw, "Assembles:\t%s\n", joinOrDash(v.Assembles))
+	fmt.Fprintf(tw, "Created:\t%s\n", formatTime(v.CreatedAt))
+	fmt.Fprintf(tw, "Finished:\t%s\n", formatTime(v.FinishedAt))
+	fmt.Fprintf(tw, "Timeout:\t%s\n", timeout)
+	fmt.Fprintf(tw, "Limits:\t%s packets, %s bytes, %s storage bytes\n",
+		formatLimit(v.MaxPackets), formatLimit(v.MaxBytes), formatLimit(v.MaxStorageBytes))
+	fmt.Fprintf(tw, "Stop reason:\t%s\n", orDash(v.StopReason))
+	if v.LastError != "" {
+		fmt.Fprintf(tw, "Error:\t%s\n", v.LastError)
+	}
+	if v.FileSize > 0 {
+		eta := "-"
+		if v.ETA > 0 {
+			eta = v.ETA.Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "Progress:\t%d/%d bytes (%.1f%%), ETA %s\n",
+			v.FileRead, v.FileSize, float64(v.FileRead)/float64(v.FileSize)*100, eta)
+	}
+	fmt.Fprintf(tw, "Received:\t%d packets, %d bytes\n", v.Received, v.Bytes)
+	fmt.Fprintf(tw, "Discarded:\t%d\n", v.Discarded)
+	fmt.Fprintf(tw, "Stored:\t%s\n", formatCounts(v.Stored))
+	fmt.Fprintf(tw, "Assembled:\t%s\n", formatCounts(v.Assembled))
+	fmt.Fprintf(tw, "Dropped:\t%d by kernel, %d by interface, %d by netrat\n", v.KernelDropped, v.IfDropped, v.UserDropped)
+
+	names := make([]string, 0, len(v.Interfaces))
+	for name := range v.Interfaces {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		i := v.Interfaces[name]
+		fmt.Fprintf(tw, "Interface %s:\t%d packets, %d bytes, %d dropped by kernel, %d by interface, %d by netrat\n",
+			name, i.Received, i.Bytes, i.KernelDropped, i.IfDropped, i.UserDropped)
+	}
+	return tw.Flush()
+}
+
+func source(w stat.Worker) string {
+	if w.Live {
+		return "dev:" + w.Src
+	}
+	return "file:" + w.Src
+}
+
+func layerStrings(types []gopacket.LayerType) []string {
+	s := make([]string, len(types))
+	for i, t := range types {
+		s[i] = strings.ToLower(t.String())
+	}
+	return s
+}
+
+func assembleStrings(types []assemble.AssembleType) []string {
+	s := make([]string, len(types))
+	for i, t := range types {
+		s[i] = string(t)
+	}
+	return s
+}
+
+func formatCounts(counts map[string]uint64) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = fmt.Sprintf("%s=%d", k, counts[k])
+	}
+	return joinOrDash(s)
+}
+
+func formatLimit(n uint64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+func joinOrDash(s []string) string {
+	return orDash(strings.Join(s, ","))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
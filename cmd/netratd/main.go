@@ -2,15 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
 
 	_ "github.com/mattn/go-sqlite3"
 	"go.uber.org/automaxprocs/maxprocs"
 
 	"github.com/onee-only/netrat/cmd/netratd/server"
 	"github.com/onee-only/netrat/internal/config"
+	"github.com/onee-only/netrat/internal/worker"
 )
 
 func init() {
@@ -18,11 +24,32 @@ func init() {
 }
 
 func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "netratd: %s\n", err)
+		os.Exit(2)
+	}
+
+	slog.SetLogLoggerLevel(cfg.LogLevel)
+
 	ctx, stop := signal.NotifyContext(context.Background(), signalsToHandle...)
 	defer stop()
 
 	opts := server.Options{
-		SocketAddr: config.DefaultServerAddr,
+		SocketAddr: cfg.SocketAddr,
+		SocketPerm: cfg.SocketPerm,
+
+		ShutdownTimeout: cfg.ShutdownTimeout,
+		ExportPath:      cfg.ExportPath,
+
+		Worker: worker.Config{
+			DataPath:            cfg.DataPath,
+			SnapLen:             cfg.Capture.SnapLen,
+			PacketStreamBufSize: cfg.Capture.PacketStreamBufSize,
+			PacketBatchSize:     cfg.Storage.BatchSize,
+			PacketFlushInterval: cfg.Storage.FlushInterval,
+			AssembleTimeout:     cfg.Assemble.Timeout,
+		},
 	}
 
 	srv := server.New(opts)
@@ -32,3 +59,83 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// loadConfig builds configuration from defaults, configuration file and flags.
+// Flags take precedence over the file.
+func loadConfig() (config.Config, error) {
+	var (
+		cfg   = config.Default()
+		flags = config.Default()
+	)
+
+	configPath := flag.String("config", config.DefaultConfigPath, "path to configuration file")
+	flag.StringVar(&flags.SocketAddr, "socket", flags.SocketAddr, "unix socket address to listen on")
+	flag.Func("socket-perm", "permission bits of the socket (default "+fmt.Sprintf("%#o", uint32(flags.SocketPerm))+")", func(s string) error {
+		perm, err := strconv.ParseUint(s, 8, 32)
+		if err != nil {
+			return err
+		}
+		flags.SocketPerm = os.FileMode(perm)
+		return nil
+	})
+	flag.StringVar(&flags.DataPath, "data", flags.DataPath, "directory to store captured data in")
+	flag.StringVar(&flags.ExportPath, "export", flags.ExportPath, "directory clients may make netratd write exports to")
+	flag.DurationVar(&flags.ShutdownTimeout, "shutdown-timeout", flags.ShutdownTimeout, "time to wait for workers to be flushed on shutdown")
+	flag.TextVar(&flags.LogLevel, "log-level", flags.LogLevel, "log level (debug, info, warn, error)")
+	flag.Func("snaplen", "default snapshot length of workers (default "+strconv.Itoa(int(flags.Capture.SnapLen))+")", func(s string) error {
+		snaplen, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return err
+		}
+		flags.Capture.SnapLen = int32(snaplen)
+		return nil
+	})
+	flag.IntVar(&flags.Capture.PacketStreamBufSize, "packet-buffer", flags.Capture.PacketStreamBufSize, "size of the captured packet channel buffer")
+	flag.IntVar(&flags.Storage.BatchSize, "batch-size", flags.Storage.BatchSize, "number of packets committed in one transaction")
+	flag.DurationVar(&flags.Storage.FlushInterval, "flush-interval", flags.Storage.FlushInterval, "max time packets wait before being committed")
+	flag.DurationVar(&flags.Assemble.Timeout, "assemble-timeout", flags.Assemble.Timeout, "idle timeout of assembled streams")
+	flag.Parse()
+
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+
+	if err := config.Load(*configPath, &cfg); err != nil {
+		// the default configuration file is optional.
+		if explicit || !errors.Is(err, fs.ErrNotExist) {
+			return cfg, err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "socket":
+			cfg.SocketAddr = flags.SocketAddr
+		case "socket-perm":
+			cfg.SocketPerm = flags.SocketPerm
+		case "data":
+			cfg.DataPath = flags.DataPath
+		case "export":
+			cfg.ExportPath = flags.ExportPath
+		case "log-level":
+			cfg.LogLevel = flags.LogLevel
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = flags.ShutdownTimeout
+		case "snaplen":
+			cfg.Capture.SnapLen = flags.Capture.SnapLen
+		case "packet-buffer":
+			cfg.Capture.PacketStreamBufSize = flags.Capture.PacketStreamBufSize
+		case "batch-size":
+			cfg.Storage.BatchSize = flags.Storage.BatchSize
+		case "flush-interval":
+			cfg.Storage.FlushInterval = flags.Storage.FlushInterval
+		case "assemble-timeout":
+			cfg.Assemble.Timeout = flags.Assemble.Timeout
+		}
+	})
+
+	return cfg, cfg.Validate()
+}
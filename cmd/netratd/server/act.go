@@ -4,19 +4,34 @@ import (
 	"context"
 	"errors"
 
-	"github.com/onee-only/netrat/internal/msg"
+	"github.com/onee-only/netrat/pkg/msg"
 )
 
 type requestHandler func(ctx context.Context, r *msg.Request) (*msg.Response, error)
 
+// streamHandler answers a request with multiple responses through send.
+type streamHandler func(ctx context.Context, r *msg.Request, send responder) error
+
+type responder func(res *msg.Response) error
+
 type actTable struct {
-	lookup map[msg.RequestType]requestHandler
+	lookup  map[msg.RequestType]requestHandler
+	streams map[msg.RequestType]streamHandler
 }
 
-func (tbl *actTable) execute(ctx context.Context, req *msg.Request) (*msg.Response, error) {
+func (tbl *actTable) execute(ctx context.Context, req *msg.Request, send responder) error {
+	if fn, ok := tbl.streams[req.Type]; ok {
+		return fn(ctx, req, send)
+	}
+
 	fn, ok := tbl.lookup[req.Type]
 	if !ok {
-		return nil, errors.New("request type not supported")
+		return errors.New("request type not supported")
+	}
+
+	res, err := fn(ctx, req)
+	if err != nil {
+		return err
 	}
-	return fn(ctx, req)
+	return send(res)
 }
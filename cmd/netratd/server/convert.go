@@ -0,0 +1,71 @@
+package server
+
+import (
+	"github.com/onee-only/netrat/internal/export"
+	"github.com/onee-only/netrat/internal/worker"
+	"github.com/onee-only/netrat/pkg/msg"
+)
+
+// workerOptions converts the wire options into the ones of worker.
+func workerOptions(o msg.WorkerOptions) worker.WorkerOptions {
+	return worker.WorkerOptions{
+		ListenOptions: worker.ListenOptions{
+			Devices:  o.Devices,
+			PcapFile: o.PcapFile,
+
+			SnapLen:     o.SnapLen,
+			Promiscuous: o.Promiscuous,
+			BPFFilter:   o.BPFFilter,
+
+			CaptureLayers: o.CaptureLayers,
+
+			Timeout:    o.Timeout,
+			MaxPackets: o.MaxPackets,
+			MaxBytes:   o.MaxBytes,
+
+			Backend: worker.Backend(o.Backend),
+			AFPacket: worker.AFPacketOptions{
+				RingSize:     o.AFPacket.RingSize,
+				BlockTimeout: o.AFPacket.BlockTimeout,
+				FanoutGroup:  o.AFPacket.FanoutGroup,
+				FanoutType:   o.AFPacket.FanoutType,
+				Sockets:      o.AFPacket.Sockets,
+			},
+
+			ReplaySpeed: o.ReplaySpeed,
+
+			BufferSize: o.BufferSize,
+			DropPolicy: worker.DropPolicy(o.DropPolicy),
+		},
+
+		AssembleTypes: o.AssembleTypes,
+
+		Pcapng: worker.PcapngOptions{
+			Enabled:        o.Pcapng.Enabled,
+			RotateSize:     o.Pcapng.RotateSize,
+			RotateInterval: o.Pcapng.RotateInterval,
+			MaxFiles:       o.Pcapng.MaxFiles,
+		},
+
+		MaxStorageBytes: o.MaxStorageBytes,
+	}
+}
+
+// exportOptions converts the wire options into the ones of export.
+func exportOptions(o msg.ExportOptions) export.Options {
+	return export.Options{
+		Format: export.Format(o.Format),
+		Path:   o.Path,
+		Filter: export.Filter{
+			Start:        o.Filter.Start,
+			End:          o.Filter.End,
+			SrcIP:        o.Filter.SrcIP,
+			DstIP:        o.Filter.DstIP,
+			SrcPort:      o.Filter.SrcPort,
+			DstPort:      o.Filter.DstPort,
+			Transport:    o.Filter.Transport,
+			Layers:       o.Filter.Layers,
+			HTTPStreamID: o.Filter.HTTPStreamID,
+		},
+	}
+}
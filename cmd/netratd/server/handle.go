@@ -1,16 +1,29 @@
 package server
 
 import (
+	"bufio"
 	"context"
-	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 
-	"github.com/onee-only/netrat/internal/msg"
+	"github.com/google/uuid"
+	"github.com/onee-only/netrat/internal/device"
+	"github.com/onee-only/netrat/internal/export"
 	"github.com/onee-only/netrat/internal/worker"
+	"github.com/onee-only/netrat/pkg/msg"
+	"github.com/pkg/errors"
 )
 
 func (srv *Server) HandleListen(ctx context.Context, r *msg.Request) (*msg.Response, error) {
-	p := r.Payload.(msg.WorkerInitPayload)
-	w, ctx, err := worker.New(ctx, &p.Opts)
+	p, ok := r.Payload.(msg.WorkerInitPayload)
+	if !ok {
+		return nil, invalidPayload(r)
+	}
+	opts := workerOptions(p.Opts)
+	// worker outlives the request, and is stopped explicitly on shutdown.
+	w, ctx, err := worker.New(context.WithoutCancel(ctx), srv.workerConfig, &opts)
 	if err != nil {
 		return nil, err
 	}
@@ -19,7 +32,7 @@ func (srv *Server) HandleListen(ctx context.Context, r *msg.Request) (*msg.Respo
 
 	go func() {
 		if err := w.Exec(ctx); err != nil {
-			log.Println(err)
+			slog.Error("worker exited with error", "id", w.ID(), "err", err)
 		}
 	}()
 
@@ -37,7 +50,10 @@ func (srv *Server) HandleList(ctx context.Context, r *msg.Request) (*msg.Respons
 }
 
 func (srv *Server) HandleStat(ctx context.Context, r *msg.Request) (*msg.Response, error) {
-	p := r.Payload.(msg.WorkerIDPayload)
+	p, ok := r.Payload.(msg.WorkerIDPayload)
+	if !ok {
+		return nil, invalidPayload(r)
+	}
 	stat, err := srv.workManager.FetchStat(p.ID)
 	if err != nil {
 		return nil, err
@@ -49,3 +65,169 @@ func (srv *Server) HandleStat(ctx context.Context, r *msg.Request) (*msg.Respons
 		},
 	}, nil
 }
+
+func (srv *Server) HandleCancel(ctx context.Context, r *msg.Request) (*msg.Response, error) {
+	p, ok := r.Payload.(msg.WorkerIDPayload)
+	if !ok {
+		return nil, invalidPayload(r)
+	}
+	if err := srv.workManager.CancelWorker(p.ID); err != nil {
+		return nil, err
+	}
+
+	return &msg.Response{Payload: msg.EmptyPayload{}}, nil
+}
+
+func (srv *Server) HandleDelete(ctx context.Context, r *msg.Request) (*msg.Response, error) {
+	p, ok := r.Payload.(msg.WorkerDeletePayload)
+	if !ok {
+		return nil, invalidPayload(r)
+	}
+	if err := srv.workManager.DeleteWorker(ctx, p.ID, p.KeepData); err != nil {
+		return nil, err
+	}
+
+	return &msg.Response{Payload: msg.EmptyPayload{}}, nil
+}
+
+func (srv *Server) HandlePause(ctx context.Context, r *msg.Request) (*msg.Response, error) {
+	p, ok := r.Payload.(msg.WorkerIDPayload)
+	if !ok {
+		return nil, invalidPayload(r)
+	}
+	if err := srv.workManager.PauseWorker(p.ID); err != nil {
+		return nil, err
+	}
+
+	return &msg.Response{Payload: msg.EmptyPayload{}}, nil
+}
+
+func (srv *Server) HandleResume(ctx context.Context, r *msg.Request) (*msg.Response, error) {
+	p, ok := r.Payload.(msg.WorkerIDPayload)
+	if !ok {
+		return nil, invalidPayload(r)
+	}
+	if err := srv.workManager.ResumeWorker(p.ID); err != nil {
+		return nil, err
+	}
+
+	return &msg.Response{Payload: msg.EmptyPayload{}}, nil
+}
+
+func (srv *Server) HandleDevices(ctx context.Context, r *msg.Request) (*msg.Response, error) {
+	devices, err := device.All()
+	if err != nil {
+		return nil, err
+	}
+
+	return &msg.Response{
+		Payload: msg.DeviceListPayload{Devices: devices},
+	}, nil
+}
+
+// invalidPayload reports request whose payload does not match its type.
+func invalidPayload(r *msg.Request) error {
+	return errors.Errorf("invalid payload %T for request type %d", r.Payload, r.Type)
+}
+
+// exportChunkSize is the size of data sent in single export response.
+const exportChunkSize = 64 << 10
+
+func (srv *Server) HandleExport(ctx context.Context, r *msg.Request, send responder) error {
+	p, ok := r.Payload.(msg.ExportPayload)
+	if !ok {
+		return invalidPayload(r)
+	}
+	opts := exportOptions(p.Opts)
+
+	var (
+		packets uint64
+		err     error
+	)
+
+	if opts.Path != "" {
+		packets, err = srv.exportToFile(ctx, p.ID, opts)
+	} else {
+		w := bufio.NewWriterSize(chunkWriter(send), exportChunkSize)
+		if packets, err = srv.workManager.Export(ctx, p.ID, opts, w); err == nil {
+			err = w.Flush()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return send(&msg.Response{
+		Payload: msg.ExportDonePayload{Packets: packets},
+	})
+}
+
+func (srv *Server) exportToFile(ctx context.Context, id uuid.UUID, opts export.Options) (uint64, error) {
+	path, err := srv.resolveExportPath(opts.Path)
+	if err != nil {
+		return 0, err
+	}
+
+	// existing file is never overwritten, so the file is ours to
+	// remove on failure.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return 0, errors.Wrap(err, "creating export file")
+	}
+
+	packets, err := srv.workManager.Export(ctx, id, opts, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+
+	return packets, nil
+}
+
+// resolveExportPath resolves path of export file against the export
+// directory. Relative path is taken from the export directory, and path
+// whose parent resolves to outside of it is rejected.
+func (srv *Server) resolveExportPath(path string) (string, error) {
+	if err := os.MkdirAll(srv.exportPath, 0750); err != nil {
+		return "", errors.Wrap(err, "creating export directory")
+	}
+
+	dir, err := filepath.EvalSymlinks(srv.exportPath)
+	if err != nil {
+		return "", errors.Wrap(err, "resolving export directory")
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return "", errors.Wrap(err, "resolving export directory")
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path = filepath.Clean(path)
+
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return "", errors.Wrap(err, "resolving export path")
+	}
+
+	rel, err := filepath.Rel(dir, parent)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("export path must be inside %s", srv.exportPath)
+	}
+
+	return filepath.Join(parent, filepath.Base(path)), nil
+}
+
+// chunkWriter sends written data as ExportChunkPayload.
+type chunkWriter responder
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	res := &msg.Response{Payload: msg.ExportChunkPayload{Data: p}}
+	if err := w(res); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -5,23 +5,43 @@ import (
 	"context"
 	"encoding/binary"
 	"io"
+	"log/slog"
 	"net"
+	"os"
 	"sync"
 	"time"
 
 	goerrors "errors"
 
-	"github.com/onee-only/netrat/internal/msg"
+	"github.com/onee-only/netrat/internal/worker"
 	workmanager "github.com/onee-only/netrat/internal/worker/manager"
+	"github.com/onee-only/netrat/pkg/msg"
 	"github.com/pkg/errors"
 )
 
 type Options struct {
 	SocketAddr string
+	SocketPerm os.FileMode
+
+	// ShutdownTimeout bounds the time Run waits for workers
+	// to be flushed after ctx is done.
+	ShutdownTimeout time.Duration
+
+	// ExportPath is the directory exports requested
+	// with export.Options.Path are confined to.
+	ExportPath string
+
+	Worker worker.Config
 }
 
 type Server struct {
 	socketAddr string
+	socketPerm os.FileMode
+
+	shutdownTimeout time.Duration
+	exportPath      string
+
+	workerConfig worker.Config
 
 	action *actTable
 
@@ -31,8 +51,13 @@ type Server struct {
 // New creates new netrat daemon.
 func New(opts Options) *Server {
 	srv := Server{
-		socketAddr:  opts.SocketAddr,
-		workManager: workmanager.New(),
+		socketAddr:   opts.SocketAddr,
+		socketPerm:   opts.SocketPerm,
+		workerConfig: opts.Worker,
+		workManager:  workmanager.New(),
+
+		shutdownTimeout: opts.ShutdownTimeout,
+		exportPath:      opts.ExportPath,
 	}
 
 	srv.action = &actTable{
@@ -40,6 +65,16 @@ func New(opts Options) *Server {
 			msg.RequestTypeListen:     srv.HandleListen,
 			msg.RequestTypeWorkerList: srv.HandleList,
 			msg.RequestTypeWorkerStat: srv.HandleStat,
+
+			msg.RequestTypeWorkerCancel: srv.HandleCancel,
+			msg.RequestTypeWorkerDelete: srv.HandleDelete,
+			msg.RequestTypeWorkerPause:  srv.HandlePause,
+			msg.RequestTypeWorkerResume: srv.HandleResume,
+
+			msg.RequestTypeDevices: srv.HandleDevices,
+		},
+		streams: map[msg.RequestType]streamHandler{
+			msg.RequestTypeExport: srv.HandleExport,
 		},
 	}
 
@@ -47,6 +82,10 @@ func New(opts Options) *Server {
 }
 
 func (srv *Server) Run(ctx context.Context) (err error) {
+	if err := srv.workManager.Restore(srv.workerConfig.DataPath); err != nil {
+		return errors.Wrap(err, "server: restoring workers")
+	}
+
 	var wg sync.WaitGroup
 
 	errchan := make(chan error, 1)
@@ -65,9 +104,25 @@ func (srv *Server) Run(ctx context.Context) (err error) {
 		err = goerrors.Join(err, e)
 	}
 
+	if e := srv.shutdown(context.WithoutCancel(ctx)); e != nil {
+		err = goerrors.Join(err, e)
+	}
+
 	return err
 }
 
+// shutdown stops every worker, letting them flush
+// captured data within the shutdown timeout.
+func (srv *Server) shutdown(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, srv.shutdownTimeout)
+	defer cancel()
+
+	if err := srv.workManager.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "server: shutting down workers")
+	}
+	return nil
+}
+
 func (srv *Server) serveUnix(ctx context.Context) error {
 	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: srv.socketAddr})
 	if err != nil {
@@ -75,6 +130,10 @@ func (srv *Server) serveUnix(ctx context.Context) error {
 	}
 	defer listener.Close()
 
+	if err := os.Chmod(srv.socketAddr, srv.socketPerm); err != nil {
+		return errors.Wrap(err, "server: changing socket permission")
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -113,22 +172,31 @@ func (srv *Server) accept(ctx context.Context, conn net.Conn) {
 				return
 			}
 
-			res := msg.NewErrResponse(err)
+			res := newErrResponse(err)
 			if err := srv.send(ctx, res, conn, lenBuf, buf); err != nil {
-				// unexpected. fatal.
+				slog.Error("server: sending error response", "err", err)
+				return
 			}
-			// TODO: maybe dump it?
 			continue
 		}
 
-		res, err := srv.action.execute(ctx, req)
-		if err != nil {
-			res = msg.NewErrResponse(err)
-			// TODO: maybe dump it?
+		// the client cannot be answered once sending failed,
+		// so the connection is dropped.
+		var sendErr error
+		send := func(res *msg.Response) error {
+			if sendErr == nil {
+				sendErr = srv.send(ctx, res, conn, lenBuf, buf)
+			}
+			return sendErr
 		}
 
-		if err := srv.send(ctx, res, conn, lenBuf, buf); err != nil {
-			// unexpected. fatal.
+		err = srv.action.execute(ctx, req, send)
+		if err != nil && sendErr == nil {
+			send(newErrResponse(err))
+		}
+		if sendErr != nil {
+			slog.Error("server: sending response", "type", req.Type, "err", sendErr)
+			return
 		}
 	}
 }
@@ -139,7 +207,7 @@ func (srv *Server) recv(ctx context.Context, conn net.Conn, lenBuf, recvBuf []by
 		case <-ctx.Done():
 			return nil, context.Cause(ctx)
 		default:
-			conn.SetDeadline(time.Now().Add(time.Second))
+			conn.SetReadDeadline(time.Now().Add(time.Second))
 		}
 
 		_, err := conn.Read(lenBuf)
@@ -159,7 +227,7 @@ func (srv *Server) recv(ctx context.Context, conn net.Conn, lenBuf, recvBuf []by
 			case <-ctx.Done():
 				return nil, context.Cause(ctx)
 			default:
-				conn.SetDeadline(time.Now().Add(time.Second))
+				conn.SetReadDeadline(time.Now().Add(time.Second))
 			}
 
 			n, err := conn.Read(recvBuf)
@@ -183,11 +251,18 @@ func (srv *Server) recv(ctx context.Context, conn net.Conn, lenBuf, recvBuf []by
 	}
 }
 
+// sendTimeout bounds writing single response, so that the handler
+// is not blocked forever by a client that stopped reading.
+const sendTimeout = 10 * time.Second
+
 func (srv *Server) send(_ context.Context, res *msg.Response, conn net.Conn, lenBuf []byte, buf *bytes.Buffer) error {
+	buf.Reset()
 	if err := res.Encode(buf); err != nil {
 		return errors.Wrap(err, "server: encoding response")
 	}
 
+	conn.SetWriteDeadline(time.Now().Add(sendTimeout))
+
 	binary.LittleEndian.PutUint32(lenBuf, uint32(buf.Len()))
 	_, err := conn.Write(lenBuf)
 	if err != nil {
@@ -201,3 +276,13 @@ func (srv *Server) send(_ context.Context, res *msg.Response, conn net.Conn, len
 
 	return nil
 }
+
+// newErrResponse reports invalid worker option with its name
+// so that the client can point at it.
+func newErrResponse(err error) *msg.Response {
+	var optErr *worker.OptionError
+	if goerrors.As(err, &optErr) {
+		return msg.NewOptionErrResponse(optErr.Option, err)
+	}
+	return msg.NewErrResponse(err)
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/onee-only/netrat/internal/worker"
+	"github.com/onee-only/netrat/pkg/client"
+	"github.com/onee-only/netrat/pkg/msg"
+	"github.com/onee-only/netrat/pkg/stat"
+)
+
+// writePcap writes n tcp packets with payload of size bytes to a pcap file in dir.
+func writePcap(t *testing.T, dir string, n, size int) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "in.pcap")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolTCP,
+			SrcIP:    net.IPv4(10, 0, 0, 1),
+			DstIP:    net.IPv4(10, 0, 0, 2),
+		}
+		tcp := &layers.TCP{SrcPort: 40000, DstPort: 80, Seq: uint32(i), ACK: true, Window: 1024}
+		tcp.SetNetworkLayerForChecksum(ip)
+
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(make([]byte, size))); err != nil {
+			t.Fatal(err)
+		}
+
+		data := buf.Bytes()
+		ci := gopacket.CaptureInfo{
+			Timestamp:     start.Add(time.Duration(i) * time.Millisecond),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path
+}
+
+// startServer runs netratd in a temporary directory
+// and returns a client connected to it.
+func startServer(t *testing.T) (*Server, *client.Client) {
+	t.Helper()
+
+	dir := t.TempDir()
+	srv := New(Options{
+		SocketAddr:      filepath.Join(dir, "netrat.sock"),
+		SocketPerm:      0600,
+		ShutdownTimeout: 10 * time.Second,
+		ExportPath:      filepath.Join(dir, "export"),
+		Worker: worker.Config{
+			DataPath:            filepath.Join(dir, "data"),
+			SnapLen:             65535,
+			PacketStreamBufSize: 16,
+			PacketBatchSize:     100,
+			PacketFlushInterval: 10 * time.Millisecond,
+			AssembleTimeout:     time.Second,
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := client.Dial(context.Background(), srv.socketAddr)
+		if err == nil {
+			t.Cleanup(func() { c.Close() })
+			return srv, c
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// listenFile starts a worker reading pcap file and waits for it to finish.
+func listenFile(t *testing.T, c *client.Client, pcapFile string, pcapng bool) uuid.UUID {
+	t.Helper()
+
+	ctx := context.Background()
+
+	var opts client.WorkerOptions
+	opts.PcapFile = pcapFile
+	opts.CaptureLayers = []gopacket.LayerType{layers.LayerTypeIPv4, layers.LayerTypeTCP}
+	opts.Pcapng.Enabled = pcapng
+
+	id, err := c.Listen(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s, err := c.Stat(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.State == stat.WorkerStateFin {
+			return id
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("worker is still %s", s.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// slowWriter stalls on the first write, like a client
+// that stops reading for a while.
+type slowWriter struct {
+	delay time.Duration
+	n     int
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		time.Sleep(w.delay)
+	}
+	w.n += len(p)
+	return len(p), nil
+}
+
+func TestExportSlowReader(t *testing.T) {
+	_, c := startServer(t)
+
+	// large enough not to fit in the socket buffer.
+	const packets = 2000
+	id := listenFile(t, c, writePcap(t, t.TempDir(), packets, 1000), true)
+
+	w := &slowWriter{delay: 2 * time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	n, err := c.Export(ctx, id, client.ExportOptions{Format: client.ExportFormatPcap}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != packets {
+		t.Fatalf("exported %d packets, want %d", n, packets)
+	}
+
+	// the connection is still usable after the export.
+	if _, err := c.Stat(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteSlow(t *testing.T) {
+	srv, c := startServer(t)
+
+	id := listenFile(t, c, writePcap(t, t.TempDir(), 10, 10), false)
+
+	// waiting for the worker to flush can outlast the read deadline
+	// set while the request was received.
+	srv.action.lookup[msg.RequestTypeWorkerDelete] = func(ctx context.Context, r *msg.Request) (*msg.Response, error) {
+		time.Sleep(2 * time.Second)
+		return srv.HandleDelete(ctx, r)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := c.Delete(ctx, id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	workers, err := c.Workers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workers) != 0 {
+		t.Fatalf("got %d workers after delete", len(workers))
+	}
+}
@@ -5,11 +5,13 @@ go 1.22.1
 require github.com/pkg/errors v0.9.1
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/google/gopacket v1.1.19
 	github.com/google/uuid v1.6.0
 	github.com/jmoiron/sqlx v1.3.5
 	github.com/mattn/go-sqlite3 v1.14.22
 	go.uber.org/automaxprocs v1.5.3
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
 )
 
-require golang.org/x/sys v0.1.0 // indirect
+require golang.org/x/sys v0.1.0
@@ -9,4 +9,8 @@ type Assembler interface {
 	Provide(packet container.Packet)
 	Valid(packet container.Packet) bool
 	Type() assemble.AssembleType
+
+	// Close flushes all pending streams and waits until
+	// assembled objects are stored.
+	Close()
 }
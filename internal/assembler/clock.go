@@ -0,0 +1,49 @@
+package assembler
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells assemblers the current time to expire idle streams with.
+type Clock interface {
+	Now() time.Time
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time { return time.Now() }
+
+// WallClock is the clock for live sources.
+var WallClock Clock = wallClock{}
+
+// PacketClock is the clock for offline sources. It is advanced by
+// timestamps of the packets, so results do not depend on how fast
+// the packets are processed.
+type PacketClock struct {
+	now atomic.Int64
+}
+
+var _ Clock = (*PacketClock)(nil)
+
+// Now returns the latest timestamp given to Advance,
+// or zero time if Advance is never called.
+func (c *PacketClock) Now() time.Time {
+	now := c.now.Load()
+	if now == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, now)
+}
+
+// Advance moves the clock to ts. Timestamps older than
+// the current time are ignored.
+func (c *PacketClock) Advance(ts time.Time) {
+	next := ts.UnixNano()
+	for {
+		now := c.now.Load()
+		if next <= now || c.now.CompareAndSwap(now, next) {
+			return
+		}
+	}
+}
@@ -1,16 +1,18 @@
 package asmfactory
 
 import (
+	"time"
+
 	"github.com/onee-only/netrat/internal/assembler"
 	"github.com/onee-only/netrat/internal/assembler/http"
 	"github.com/onee-only/netrat/internal/storage"
 	"github.com/onee-only/netrat/pkg/assemble"
 )
 
-func New(t assemble.AssembleType, storage storage.AssembleObjectStorage) assembler.Assembler {
+func New(t assemble.AssembleType, storage storage.AssembleObjectStorage, timeout time.Duration, clock assembler.Clock) assembler.Assembler {
 	switch t {
 	case assemble.AssembleTypeHTTP:
-		return http.NewHTTPAssembler(storage)
+		return http.NewHTTPAssembler(storage, timeout, clock)
 	}
 
 	return nil
@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/gopacket"
 	"github.com/google/uuid"
+	"github.com/onee-only/netrat/internal/assembler"
 	"github.com/onee-only/netrat/pkg/util"
 )
 
@@ -16,6 +17,8 @@ type connInfo struct {
 
 type connPairer struct {
 	connections map[[2]gopacket.Flow]connInfo
+	timeout     time.Duration
+	clock       assembler.Clock
 	lock        sync.Mutex
 }
 
@@ -28,8 +31,9 @@ func (p *connPairer) pairOrNew(dir [2]gopacket.Flow) (id uuid.UUID, found bool)
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
+	now := p.clock.Now()
 	if info, ok := p.connections[reversed]; ok {
-		if !info.deadline.Before(time.Now()) {
+		if !info.deadline.Before(now) {
 			delete(p.connections, reversed)
 			return info.id, true
 		}
@@ -38,7 +42,7 @@ func (p *connPairer) pairOrNew(dir [2]gopacket.Flow) (id uuid.UUID, found bool)
 	id = uuid.New()
 	info := connInfo{
 		id:       id,
-		deadline: time.Now().Add(timeout),
+		deadline: now.Add(p.timeout),
 	}
 
 	p.connections[dir] = info
@@ -50,7 +54,7 @@ func (p *connPairer) flush() (flushed int) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	now := time.Now()
+	now := p.clock.Now()
 	for key, info := range p.connections {
 		if info.deadline.Before(now) {
 			delete(p.connections, key)
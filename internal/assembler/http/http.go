@@ -2,6 +2,7 @@ package http
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/google/gopacket"
@@ -13,22 +14,40 @@ import (
 	"github.com/onee-only/netrat/pkg/assemble"
 )
 
-const timeout = 30 * time.Second
-
 type HTTPAssembler struct {
 	tcpasm     *tcpassembly.Assembler
 	connPairer *connPairer
 	storage    storage.AssembleObjectStorage
+	timeout    time.Duration
+	clock      assembler.Clock
+
+	// tcpassembly.Assembler is not safe for concurrent use.
+	asmLock   sync.Mutex
+	lastFlush time.Time
+
+	// streams tracks stream readers and pending stores.
+	streams *sync.WaitGroup
 
 	cancel func()
+	done   chan struct{}
 }
 
 var _ assembler.Assembler = (*HTTPAssembler)(nil)
 
-func NewHTTPAssembler(s storage.AssembleObjectStorage) *HTTPAssembler {
+// NewHTTPAssembler creates HTTP assembler.
+// Streams and connections idle longer than timeout by clock are flushed.
+func NewHTTPAssembler(s storage.AssembleObjectStorage, timeout time.Duration, clock assembler.Clock) *HTTPAssembler {
 	asm := &HTTPAssembler{
-		storage:    s,
-		connPairer: &connPairer{connections: make(map[[2]gopacket.Flow]connInfo)},
+		storage: s,
+		timeout: timeout,
+		clock:   clock,
+		connPairer: &connPairer{
+			connections: make(map[[2]gopacket.Flow]connInfo),
+			timeout:     timeout,
+			clock:       clock,
+		},
+		streams: new(sync.WaitGroup),
+		done:    make(chan struct{}),
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -39,30 +58,61 @@ func NewHTTPAssembler(s storage.AssembleObjectStorage) *HTTPAssembler {
 		pctx:       ctx,
 		asmStorage: asm.storage,
 		connPairer: asm.connPairer,
+		wg:         asm.streams,
 	}
 
 	streamPool := tcpassembly.NewStreamPool(factory)
 	asm.tcpasm = tcpassembly.NewAssembler(streamPool)
 
-	go func() {
-		t := time.NewTicker(timeout)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-t.C:
-				asm.tcpasm.FlushOlderThan(time.Now().Add(-timeout))
-				asm.connPairer.flush()
-			}
-		}
-	}()
+	if clock == assembler.WallClock {
+		go asm.tick(ctx)
+	} else {
+		// packet clock only advances with packets,
+		// so Provide is the only place to flush.
+		close(asm.done)
+	}
 
 	return asm
 }
 
+// tick flushes idle streams while no packets arrive.
+func (asm *HTTPAssembler) tick(ctx context.Context) {
+	defer close(asm.done)
+
+	t := time.NewTicker(asm.timeout)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			asm.asmLock.Lock()
+			asm.flush(asm.clock.Now())
+			asm.asmLock.Unlock()
+		}
+	}
+}
+
+// flush must be called with asmLock held.
+func (asm *HTTPAssembler) flush(now time.Time) {
+	asm.lastFlush = now
+	asm.tcpasm.FlushOlderThan(now.Add(-asm.timeout))
+	asm.connPairer.flush()
+}
+
 func (asm *HTTPAssembler) Provide(packet container.Packet) {
 	tcpPacket := packet.TransportLayer().(*layers.TCP)
 
+	asm.asmLock.Lock()
+	defer asm.asmLock.Unlock()
+
+	// flush before assembling, so the packet does not
+	// extend a stream that was idle for too long.
+	if now := asm.clock.Now(); now.Sub(asm.lastFlush) >= asm.timeout {
+		asm.flush(now)
+	}
+
 	asm.tcpasm.AssembleWithTimestamp(
 		packet.NetworkLayer().NetworkFlow(),
 		tcpPacket, packet.Metadata().Timestamp,
@@ -81,3 +131,16 @@ func (asm *HTTPAssembler) Valid(packet container.Packet) bool {
 func (asm *HTTPAssembler) Type() assemble.AssembleType {
 	return assemble.AssembleTypeHTTP
 }
+
+func (asm *HTTPAssembler) Close() {
+	asm.asmLock.Lock()
+	asm.tcpasm.FlushAll()
+	asm.asmLock.Unlock()
+
+	// every stream is completed by FlushAll, so readers return
+	// after reading the rest of their streams.
+	asm.streams.Wait()
+
+	asm.cancel()
+	<-asm.done
+}
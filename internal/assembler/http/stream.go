@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"sync"
@@ -21,6 +22,8 @@ type httpStreamFactory struct {
 
 	connPairer *connPairer
 	asmStorage storage.AssembleObjectStorage
+
+	wg *sync.WaitGroup
 }
 
 // Only supports HTTP/1.X
@@ -40,6 +43,7 @@ type httpStream struct {
 	firstSeen, lastSeen time.Time
 
 	asmStorage storage.AssembleObjectStorage
+	wg         *sync.WaitGroup
 
 	ctx    context.Context
 	cancel func()
@@ -57,16 +61,21 @@ func (factory *httpStreamFactory) New(net, transport gopacket.Flow) tcpassembly.
 
 		reasmStream: make(chan tcpassembly.Reassembly),
 		asmStorage:  factory.asmStorage,
+		wg:          factory.wg,
 	}
 
 	s.ctx, s.cancel = context.WithCancel(factory.pctx)
 
+	s.wg.Add(1)
 	go s.readHTTP()
 
 	return s
 }
 
 func (s *httpStream) readHTTP() {
+	defer s.wg.Done()
+	defer s.cancel()
+
 	r := bufio.NewReader(s)
 
 	resetReadable := func() {
@@ -86,6 +95,9 @@ func (s *httpStream) readHTTP() {
 		if s.isServer {
 			res, err := http.ReadResponse(r, nil)
 			if err != nil {
+				if isEOF(err) {
+					return
+				}
 				resetReadable()
 				continue
 			}
@@ -93,29 +105,38 @@ func (s *httpStream) readHTTP() {
 		} else {
 			req, err := http.ReadRequest(r)
 			if err != nil {
+				if isEOF(err) {
+					return
+				}
 				resetReadable()
 				continue
 			}
 			w = req
 		}
 
+		s.readLock.Lock()
 		s.lastSeen = s.buffered.Seen
+		metadata := container.HTTPAsmMetadata{
+			ID:         uuid.New(),
+			StreamID:   s.id,
+			Net:        s.net,
+			Transport:  s.transport,
+			Start:      s.firstSeen,
+			End:        s.lastSeen,
+			IsResponse: s.isServer,
+		}
+		s.readLock.Unlock()
 
 		b := new(bytes.Buffer)
 		w.Write(b)
 
+		s.wg.Add(1)
 		go func() {
+			defer s.wg.Done()
+
 			err := s.asmStorage.Store(context.WithoutCancel(s.ctx), container.Assembly{
-				Object: b,
-				Metadata: container.HTTPAsmMetadata{
-					ID:         uuid.New(),
-					StreamID:   s.id,
-					Net:        s.net,
-					Transport:  s.transport,
-					Start:      s.firstSeen,
-					End:        s.lastSeen,
-					IsResponse: s.isServer,
-				},
+				Object:   b,
+				Metadata: metadata,
 			})
 			if err != nil {
 				panic(err)
@@ -148,7 +169,8 @@ func (s *httpStream) Reassembled(reassemblies []tcpassembly.Reassembly) {
 }
 
 func (s *httpStream) ReassemblyComplete() {
-	s.cleanUp()
+	// let the reader consume what is left, then see EOF.
+	close(s.reasmStream)
 }
 
 func (s *httpStream) Read(p []byte) (int, error) {
@@ -165,6 +187,6 @@ func (s *httpStream) Read(p []byte) (int, error) {
 	return length, nil
 }
 
-func (s *httpStream) cleanUp() {
-	s.cancel()
+func isEOF(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
 }
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of netratd.
+type Config struct {
+	SocketAddr string      `toml:"socket"`
+	SocketPerm os.FileMode `toml:"socket_perm"`
+	DataPath   string      `toml:"data_path"`
+	LogLevel   slog.Level  `toml:"log_level"`
+
+	// ExportPath is the only directory netratd writes exports to
+	// on behalf of clients.
+	ExportPath string `toml:"export_path"`
+
+	// ShutdownTimeout bounds the time spent on
+	// flushing and closing workers on shutdown.
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"`
+
+	Capture  CaptureConfig  `toml:"capture"`
+	Storage  StorageConfig  `toml:"storage"`
+	Assemble AssembleConfig `toml:"assemble"`
+}
+
+type CaptureConfig struct {
+	SnapLen             int32 `toml:"snaplen"`
+	PacketStreamBufSize int   `toml:"packet_buffer"`
+}
+
+// StorageConfig configures how captured packets are written.
+// Packets are committed when BatchSize packets are queued
+// or FlushInterval has passed.
+type StorageConfig struct {
+	BatchSize     int           `toml:"batch_size"`
+	FlushInterval time.Duration `toml:"flush_interval"`
+}
+
+type AssembleConfig struct {
+	Timeout time.Duration `toml:"timeout"`
+}
+
+// Default returns the configuration netratd uses
+// when no configuration file is given.
+func Default() Config {
+	return Config{
+		SocketAddr: DefaultServerAddr,
+		SocketPerm: DefaultSocketPerm,
+		DataPath:   DefaultDataPath,
+		LogLevel:   DefaultLogLevel,
+		ExportPath: DefaultExportPath,
+
+		ShutdownTimeout: DefaultShutdownTimeout,
+		Capture: CaptureConfig{
+			SnapLen:             PacketSnapLen,
+			PacketStreamBufSize: PacketStreamBufSize,
+		},
+		Storage: StorageConfig{
+			BatchSize:     PacketBatchSize,
+			FlushInterval: PacketFlushInterval,
+		},
+		Assemble: AssembleConfig{
+			Timeout: AssembleTimeout,
+		},
+	}
+}
+
+// Load reads TOML configuration file at path onto c.
+// Values not present in the file are left untouched.
+func Load(path string, c *Config) error {
+	md, err := toml.DecodeFile(path, c)
+	if err != nil {
+		return errors.Wrap(err, "config: decoding file")
+	}
+
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return errors.Errorf("config: unknown key %q", undecoded[0].String())
+	}
+
+	return c.Validate()
+}
+
+func (c *Config) Validate() error {
+	if c.SocketAddr == "" {
+		return errors.New("config: socket address is empty")
+	}
+	if c.DataPath == "" {
+		return errors.New("config: data path is empty")
+	}
+	if c.ExportPath == "" {
+		return errors.New("config: export path is empty")
+	}
+	if c.ShutdownTimeout <= 0 {
+		return errors.New("config: shutdown timeout must be positive")
+	}
+	if c.Capture.SnapLen <= 0 {
+		return errors.New("config: snaplen must be positive")
+	}
+	if c.Capture.PacketStreamBufSize < 0 {
+		return errors.New("config: packet buffer size must not be negative")
+	}
+	if c.Storage.BatchSize <= 0 {
+		return errors.New("config: batch size must be positive")
+	}
+	if c.Storage.FlushInterval <= 0 {
+		return errors.New("config: flush interval must be positive")
+	}
+	if c.Assemble.Timeout <= 0 {
+		return errors.New("config: assemble timeout must be positive")
+	}
+	return nil
+}
@@ -1,12 +1,29 @@
 package config
 
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
 const (
+	DefaultConfigPath = "/etc/netrat/netratd.toml"
 	DefaultServerAddr = "/var/run/netrat.sock"
 	DefaultDataPath   = "/tmp/netratd"
+	DefaultExportPath = "/tmp/netratd-export"
+
+	DefaultSocketPerm      os.FileMode = 0660
+	DefaultLogLevel                    = slog.LevelInfo
+	DefaultShutdownTimeout             = 10 * time.Second
 )
 
 const (
 	PacketSnapLen int32 = 65535
 
 	PacketStreamBufSize = 10
+
+	PacketBatchSize     = 1000
+	PacketFlushInterval = 500 * time.Millisecond
+
+	AssembleTimeout = 30 * time.Second
 )
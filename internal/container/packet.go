@@ -9,4 +9,8 @@ type Packet struct {
 	gopacket.Packet
 
 	ID uuid.UUID
+
+	// Interface is the name of the device packet is captured from.
+	// It is empty for packets read from pcap file.
+	Interface string
 }
@@ -0,0 +1,96 @@
+// Package device enumerates the devices packets can be captured from.
+package device
+
+import (
+	"github.com/google/gopacket/pcap"
+	"github.com/onee-only/netrat/pkg/device"
+	"github.com/pkg/errors"
+)
+
+// interface flags reported by pcap_findalldevs.
+const (
+	pcapIfLoopback uint32 = 1 << iota
+	pcapIfUp
+	pcapIfRunning
+	pcapIfWireless
+)
+
+var ErrNotFound = errors.New("device not found")
+
+// All returns every device available for capturing.
+func All() ([]device.Device, error) {
+	ifs, err := pcap.FindAllDevs()
+	if err != nil {
+		return nil, errors.Wrap(err, "device: finding devices")
+	}
+
+	devices := make([]device.Device, len(ifs))
+	for i, iface := range ifs {
+		devices[i] = fromInterface(iface)
+	}
+
+	return devices, nil
+}
+
+// Lookup returns the device with given name.
+// It returns ErrNotFound if there is no such device.
+func Lookup(name string) (device.Device, error) {
+	ifs, err := pcap.FindAllDevs()
+	if err != nil {
+		return device.Device{}, errors.Wrap(err, "device: finding devices")
+	}
+
+	for _, iface := range ifs {
+		if iface.Name == name {
+			return fromInterface(iface), nil
+		}
+	}
+
+	return device.Device{}, ErrNotFound
+}
+
+func fromInterface(iface pcap.Interface) device.Device {
+	d := device.Device{
+		Name:        iface.Name,
+		Description: iface.Description,
+		Addresses:   make([]device.Address, len(iface.Addresses)),
+
+		Up:       iface.Flags&pcapIfUp != 0,
+		Loopback: iface.Flags&pcapIfLoopback != 0,
+		Running:  iface.Flags&pcapIfRunning != 0,
+		Wireless: iface.Flags&pcapIfWireless != 0,
+
+		LinkTypes: linkTypes(iface.Name),
+	}
+
+	for i, addr := range iface.Addresses {
+		d.Addresses[i] = device.Address{
+			IP:        addr.IP,
+			Netmask:   addr.Netmask,
+			Broadaddr: addr.Broadaddr,
+			P2P:       addr.P2P,
+		}
+	}
+
+	return d
+}
+
+// linkTypes opens the device briefly to list its data link types.
+func linkTypes(name string) []device.LinkType {
+	handle, err := pcap.OpenLive(name, 64, false, pcap.BlockForever)
+	if err != nil {
+		return nil
+	}
+	defer handle.Close()
+
+	links, err := handle.ListDataLinks()
+	if err != nil {
+		return nil
+	}
+
+	types := make([]device.LinkType, len(links))
+	for i, link := range links {
+		types[i] = device.LinkType{Name: link.Name, Description: link.Description}
+	}
+	return types
+}
@@ -0,0 +1,207 @@
+// Package export writes packets captured by a worker back to pcap or pcapng.
+//
+// Raw packets are only kept by workers with pcapng dump enabled,
+// so only those workers can be exported.
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+type Format string
+
+const (
+	FormatPcap   Format = "pcap"
+	FormatPcapng Format = "pcapng"
+)
+
+func (f Format) Valid() bool {
+	switch f {
+	case FormatPcap, FormatPcapng:
+		return true
+	}
+	return false
+}
+
+type Options struct {
+	Format Format
+
+	// Path is the file the server writes the export to.
+	// If empty, the export is streamed back to the client.
+	Path string
+
+	Filter Filter
+}
+
+// Filter selects packets to export. Zero value fields match every packet.
+type Filter struct {
+	// Start and End limit the capture timestamp to [Start, End).
+	Start, End time.Time
+
+	SrcIP, DstIP     net.IP
+	SrcPort, DstPort uint16
+	// Transport is either layers.LayerTypeTCP or layers.LayerTypeUDP.
+	Transport gopacket.LayerType
+
+	// Layers are the layers every exported packet must have.
+	Layers []gopacket.LayerType
+
+	// HTTPStreamID selects packets of the http stream
+	// with given id, in both directions.
+	HTTPStreamID uuid.UUID
+}
+
+// ErrNoRawPackets is returned when the worker did not keep raw packets.
+var ErrNoRawPackets = errors.New("worker did not keep raw packets, enable pcapng dump to export")
+
+// layerTables maps layer types to the tables they are stored in.
+var layerTables = map[gopacket.LayerType]string{
+	layers.LayerTypeEthernet: "ethernet",
+	layers.LayerTypeDot1Q:    "dot1q",
+	layers.LayerTypeARP:      "arp",
+	layers.LayerTypeIPv4:     "ipv4",
+	layers.LayerTypeIPv6:     "ipv6",
+	layers.LayerTypeTCP:      "tcp",
+	layers.LayerTypeUDP:      "udp",
+	layers.LayerTypeICMPv4:   "icmpv4",
+	layers.LayerTypeICMPv6:   "icmpv6",
+	layers.LayerTypeDNS:      "dns_header",
+}
+
+// Export writes packets of the worker namespace at path matching
+// opts.Filter to w, and returns the number of packets written.
+//
+// Packets of running worker that are not flushed to disk yet, or whose
+// pcapng file is removed by rotation meanwhile, are skipped.
+func Export(ctx context.Context, path string, opts Options, w io.Writer) (uint64, error) {
+	if !opts.Format.Valid() {
+		return 0, fmt.Errorf("export: invalid format %q", opts.Format)
+	}
+
+	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s/captured.db?mode=ro", path))
+	if err != nil {
+		return 0, errors.Wrap(err, "export: opening db")
+	}
+	defer db.Close()
+
+	tables, err := listTables(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	if !tables["pcapng_packet"] {
+		return 0, ErrNoRawPackets
+	}
+
+	q, err := newQuery(ctx, db, tables, opts.Filter)
+	if err != nil {
+		return 0, err
+	}
+
+	ifaces, err := readInterfaces(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := newWriter(opts.Format, ifaces, w)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.QueryxContext(ctx, q.sql, q.args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "export: selecting packets")
+	}
+	defer rows.Close()
+
+	files := newFileSet(path)
+	defer files.Close()
+
+	var written uint64
+	for rows.Next() {
+		var r packetRow
+		if err := rows.StructScan(&r); err != nil {
+			return written, errors.Wrap(err, "export: scanning packet")
+		}
+
+		if !q.match(r.Timestamp) {
+			continue
+		}
+
+		ci, data, err := files.read(r.File, r.Offset)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// not flushed yet.
+				continue
+			}
+			if errors.Is(err, fs.ErrNotExist) {
+				// removed by rotation of running worker.
+				continue
+			}
+			return written, err
+		}
+
+		if err := out.WritePacket(ci, data); err != nil {
+			return written, errors.Wrap(err, "export: writing packet")
+		}
+		written++
+	}
+
+	if err := rows.Err(); err != nil {
+		return written, errors.Wrap(err, "export: iterating packets")
+	}
+
+	if err := out.Flush(); err != nil {
+		return written, errors.Wrap(err, "export: flushing")
+	}
+
+	return written, nil
+}
+
+type packetRow struct {
+	Timestamp time.Time `db:"timestamp"`
+	File      string    `db:"file"`
+	Offset    int64     `db:"offset"`
+}
+
+func listTables(ctx context.Context, db *sqlx.DB) (map[string]bool, error) {
+	var names []string
+	if err := db.SelectContext(ctx, &names, "SELECT name FROM sqlite_master WHERE type = 'table'"); err != nil {
+		return nil, errors.Wrap(err, "export: listing tables")
+	}
+
+	tables := make(map[string]bool, len(names))
+	for _, name := range names {
+		tables[name] = true
+	}
+	return tables, nil
+}
+
+type iface struct {
+	Idx      int             `db:"idx"`
+	Name     string          `db:"name"`
+	LinkType layers.LinkType `db:"link_type"`
+	SnapLen  uint32          `db:"snaplen"`
+}
+
+func readInterfaces(ctx context.Context, db *sqlx.DB) ([]iface, error) {
+	var ifaces []iface
+	if err := db.SelectContext(ctx, &ifaces, "SELECT * FROM pcapng_interface ORDER BY idx"); err != nil {
+		return nil, errors.Wrap(err, "export: selecting interfaces")
+	}
+	if len(ifaces) == 0 {
+		return nil, ErrNoRawPackets
+	}
+	return ifaces, nil
+}
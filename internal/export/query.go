@@ -0,0 +1,272 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// query selects the packets matching filter in capture order.
+//
+// Timestamps are stored as text which does not sort by time,
+// so the time range is checked by match instead of sql.
+type query struct {
+	sql  string
+	args []any
+
+	start, end time.Time
+}
+
+func (q *query) match(ts time.Time) bool {
+	if !q.start.IsZero() && ts.Before(q.start) {
+		return false
+	}
+	if !q.end.IsZero() && !ts.Before(q.end) {
+		return false
+	}
+	return true
+}
+
+func newQuery(ctx context.Context, db *sqlx.DB, tables map[string]bool, f Filter) (*query, error) {
+	q := &query{start: f.Start, end: f.End}
+	b := &condBuilder{tables: tables}
+
+	for _, t := range f.Layers {
+		table, ok := layerTables[t]
+		if !ok || !tables[table] {
+			return nil, fmt.Errorf("export: layer %s is not captured", t)
+		}
+		b.add(fmt.Sprintf("EXISTS (SELECT 1 FROM %s WHERE %s.id = p.id)", table, table))
+	}
+
+	if f.Transport != 0 && f.Transport != layers.LayerTypeTCP && f.Transport != layers.LayerTypeUDP {
+		return nil, fmt.Errorf("export: invalid transport %s", f.Transport)
+	}
+
+	if err := b.addTuple(tuple{
+		srcIP: f.SrcIP, dstIP: f.DstIP,
+		srcPort: f.SrcPort, dstPort: f.DstPort,
+		transport: f.Transport,
+	}); err != nil {
+		return nil, err
+	}
+
+	if f.HTTPStreamID != uuid.Nil {
+		start, end, err := b.addHTTPStream(ctx, db, f.HTTPStreamID)
+		if err != nil {
+			return nil, err
+		}
+		if q.start.IsZero() || start.After(q.start) {
+			q.start = start
+		}
+		// end of the stream is the timestamp of its last packet.
+		end = end.Add(time.Nanosecond)
+		if q.end.IsZero() || end.Before(q.end) {
+			q.end = end
+		}
+	}
+
+	q.sql = `
+		SELECT p.timestamp, f.name AS file, pp.offset
+		FROM packet p
+		JOIN pcapng_packet pp ON pp.id = p.id
+		JOIN pcapng_file f ON f.id = pp.file_id`
+	if len(b.conds) > 0 {
+		q.sql += "\nWHERE " + strings.Join(b.conds, " AND ")
+	}
+	q.sql += "\nORDER BY pp.file_id, pp.offset"
+	q.args = b.args
+
+	return q, nil
+}
+
+type tuple struct {
+	srcIP, dstIP     net.IP
+	srcPort, dstPort uint16
+	transport        gopacket.LayerType
+}
+
+type condBuilder struct {
+	tables map[string]bool
+
+	conds []string
+	args  []any
+}
+
+func (b *condBuilder) add(cond string, args ...any) {
+	b.conds = append(b.conds, cond)
+	b.args = append(b.args, args...)
+}
+
+// addTuple adds conditions matching t. Zero value fields match anything.
+func (b *condBuilder) addTuple(t tuple) error {
+	cond, args, err := b.tupleCond(t)
+	if err != nil {
+		return err
+	}
+	if cond != "" {
+		b.add(cond, args...)
+	}
+	return nil
+}
+
+func (b *condBuilder) tupleCond(t tuple) (string, []any, error) {
+	var (
+		conds []string
+		args  []any
+	)
+
+	for _, ep := range []struct {
+		col string
+		ip  net.IP
+	}{{"src", t.srcIP}, {"dst", t.dstIP}} {
+		if ep.ip == nil {
+			continue
+		}
+
+		table, ip := "ipv6", ep.ip.To16()
+		if ip4 := ep.ip.To4(); ip4 != nil {
+			table, ip = "ipv4", ip4
+		}
+		if !b.tables[table] {
+			return "", nil, fmt.Errorf("export: %s is not captured", table)
+		}
+
+		conds = append(conds, fmt.Sprintf("EXISTS (SELECT 1 FROM %s WHERE %s.id = p.id AND %s.%s = ?)", table, table, table, ep.col))
+		args = append(args, []byte(ip))
+	}
+
+	if t.srcPort != 0 || t.dstPort != 0 || t.transport != 0 {
+		transports := []string{"tcp", "udp"}
+		if t.transport != 0 {
+			transports = []string{layerTables[t.transport]}
+		}
+
+		var portConds []string
+		for _, table := range transports {
+			if !b.tables[table] {
+				continue
+			}
+
+			cond := fmt.Sprintf("SELECT 1 FROM %s WHERE %s.id = p.id", table, table)
+			if t.srcPort != 0 {
+				cond += fmt.Sprintf(" AND %s.src = ?", table)
+				args = append(args, t.srcPort)
+			}
+			if t.dstPort != 0 {
+				cond += fmt.Sprintf(" AND %s.dst = ?", table)
+				args = append(args, t.dstPort)
+			}
+			portConds = append(portConds, "EXISTS ("+cond+")")
+		}
+
+		if len(portConds) == 0 {
+			return "", nil, errors.New("export: transport layer is not captured")
+		}
+		conds = append(conds, "("+strings.Join(portConds, " OR ")+")")
+	}
+
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// addHTTPStream adds conditions matching tcp packets of the http stream
+// with given id, and returns the time range of the stream.
+func (b *condBuilder) addHTTPStream(ctx context.Context, db *sqlx.DB, id uuid.UUID) (start, end time.Time, err error) {
+	if !b.tables["http"] {
+		return start, end, errors.New("export: http is not assembled")
+	}
+
+	var rows []struct {
+		Src   string    `db:"src"`
+		Dst   string    `db:"dst"`
+		Start time.Time `db:"start"`
+		End   time.Time `db:"end"`
+	}
+	err = db.SelectContext(ctx, &rows, "SELECT src, dst, start, end FROM http WHERE sid = ?", id[:])
+	if err != nil {
+		return start, end, errors.Wrap(err, "export: selecting http stream")
+	}
+	if len(rows) == 0 {
+		return start, end, fmt.Errorf("export: http stream %s not found", id)
+	}
+
+	var (
+		conds []string
+		args  []any
+		seen  = make(map[[2]string]bool)
+	)
+
+	for _, r := range rows {
+		if start.IsZero() || r.Start.Before(start) {
+			start = r.Start
+		}
+		if r.End.After(end) {
+			end = r.End
+		}
+
+		// requests and responses are in opposite directions.
+		for _, dir := range [][2]string{{r.Src, r.Dst}, {r.Dst, r.Src}} {
+			if seen[dir] {
+				continue
+			}
+			seen[dir] = true
+
+			t, err := endpointTuple(dir[0], dir[1])
+			if err != nil {
+				return start, end, err
+			}
+
+			cond, condArgs, err := b.tupleCond(t)
+			if err != nil {
+				return start, end, err
+			}
+			conds = append(conds, "("+cond+")")
+			args = append(args, condArgs...)
+		}
+	}
+
+	b.add("("+strings.Join(conds, " OR ")+")", args...)
+	return start, end, nil
+}
+
+// endpointTuple parses endpoints formatted by util.EndpointToString.
+func endpointTuple(src, dst string) (t tuple, err error) {
+	t.transport = layers.LayerTypeTCP
+
+	if t.srcIP, t.srcPort, err = parseEndpoint(src); err != nil {
+		return t, err
+	}
+	if t.dstIP, t.dstPort, err = parseEndpoint(dst); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+func parseEndpoint(s string) (net.IP, uint16, error) {
+	idx := strings.LastIndexByte(s, ':')
+	if idx < 0 {
+		return nil, 0, fmt.Errorf("export: invalid endpoint %q", s)
+	}
+
+	ip := net.ParseIP(s[:idx])
+	if ip == nil {
+		return nil, 0, fmt.Errorf("export: invalid endpoint ip %q", s)
+	}
+
+	// tcp port endpoint may be formatted with its well known name.
+	port, err := strconv.ParseUint(strings.SplitN(s[idx+1:], "(", 2)[0], 10, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("export: invalid endpoint port %q", s)
+	}
+
+	return ip, uint16(port), nil
+}
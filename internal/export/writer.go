@@ -0,0 +1,119 @@
+package export
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/pkg/errors"
+)
+
+type packetWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+	Flush() error
+}
+
+func newWriter(format Format, ifaces []iface, w io.Writer) (packetWriter, error) {
+	if format == FormatPcap {
+		for _, i := range ifaces[1:] {
+			if i.LinkType != ifaces[0].LinkType {
+				return nil, errors.New("export: pcap cannot hold packets of different link types, use pcapng")
+			}
+		}
+
+		writer := pcapgo.NewWriterNanos(w)
+		if err := writer.WriteFileHeader(ifaces[0].SnapLen, ifaces[0].LinkType); err != nil {
+			return nil, errors.Wrap(err, "export: writing pcap header")
+		}
+		return pcapWriter{writer}, nil
+	}
+
+	writer, err := pcapgo.NewNgWriterInterface(w, ngInterface(ifaces[0]), pcapgo.DefaultNgWriterOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "export: writing pcapng header")
+	}
+	for _, i := range ifaces[1:] {
+		if _, err := writer.AddInterface(ngInterface(i)); err != nil {
+			return nil, errors.Wrap(err, "export: adding interface")
+		}
+	}
+	return writer, nil
+}
+
+func ngInterface(i iface) pcapgo.NgInterface {
+	return pcapgo.NgInterface{
+		Name:       i.Name,
+		LinkType:   i.LinkType,
+		SnapLength: i.SnapLen,
+	}
+}
+
+// pcapWriter adapts pcapgo.Writer, which is not buffered.
+type pcapWriter struct{ *pcapgo.Writer }
+
+func (pcapWriter) Flush() error { return nil }
+
+// enhancedPacketHeaderLen is the length of enhanced packet block
+// up to the packet data.
+const enhancedPacketHeaderLen = 28
+
+// fileSet reads packets from the pcapng files of a worker.
+type fileSet struct {
+	base  string
+	files map[string]*os.File
+}
+
+func newFileSet(path string) *fileSet {
+	return &fileSet{
+		base:  filepath.Join(path, "pcapng"),
+		files: make(map[string]*os.File),
+	}
+}
+
+// read reads enhanced packet block at offset of the file.
+// Timestamp of the file is in nanoseconds.
+func (s *fileSet) read(name string, offset int64) (ci gopacket.CaptureInfo, data []byte, err error) {
+	f, ok := s.files[name]
+	if !ok {
+		if f, err = os.Open(filepath.Join(s.base, name)); err != nil {
+			return ci, nil, errors.Wrap(err, "export: opening pcapng file")
+		}
+		s.files[name] = f
+	}
+
+	header := make([]byte, enhancedPacketHeaderLen)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return ci, nil, err
+	}
+
+	if blockType := binary.LittleEndian.Uint32(header[0:4]); blockType != 0x00000006 {
+		return ci, nil, fmt.Errorf("export: unexpected block type %#x in %s at %d", blockType, name, offset)
+	}
+
+	ts := int64(binary.LittleEndian.Uint32(header[12:16]))<<32 | int64(binary.LittleEndian.Uint32(header[16:20]))
+
+	ci = gopacket.CaptureInfo{
+		Timestamp:      time.Unix(0, ts),
+		InterfaceIndex: int(binary.LittleEndian.Uint32(header[8:12])),
+		CaptureLength:  int(binary.LittleEndian.Uint32(header[20:24])),
+		Length:         int(binary.LittleEndian.Uint32(header[24:28])),
+	}
+
+	data = make([]byte, ci.CaptureLength)
+	if _, err := f.ReadAt(data, offset+enhancedPacketHeaderLen); err != nil {
+		return ci, nil, err
+	}
+
+	return ci, data, nil
+}
+
+func (s *fileSet) Close() {
+	for _, f := range s.files {
+		f.Close()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/onee-only/netrat/internal/container"
@@ -17,7 +18,7 @@ type AssembleObjectStorage interface {
 }
 
 type AssembleStorage struct {
-	objectStorages map[assemble.AssembleType]AssembleObjectStorage
+	objectStorages map[assemble.AssembleType]*countingObjectStorage
 
 	db   *sqlx.DB
 	base string
@@ -25,7 +26,7 @@ type AssembleStorage struct {
 
 func NewAssembleStorage(capStorage *CaptureStorage) (*AssembleStorage, error) {
 	storage := &AssembleStorage{
-		objectStorages: make(map[assemble.AssembleType]AssembleObjectStorage),
+		objectStorages: make(map[assemble.AssembleType]*countingObjectStorage),
 		db:             capStorage.db,
 	}
 
@@ -43,7 +44,36 @@ func (s *AssembleStorage) Register(t assemble.AssembleType, storage AssembleObje
 		return errors.Wrap(err, "assemble storage: registering object storage")
 	}
 
-	s.objectStorages[t] = storage
+	s.objectStorages[t] = &countingObjectStorage{AssembleObjectStorage: storage}
 
 	return nil
 }
+
+// ObjectStorage returns registered object storage of type t.
+// Assemblies stored through it are counted in Stored.
+func (s *AssembleStorage) ObjectStorage(t assemble.AssembleType) AssembleObjectStorage {
+	return s.objectStorages[t]
+}
+
+// Stored returns the number of stored assemblies for each registered type.
+func (s *AssembleStorage) Stored() map[assemble.AssembleType]uint64 {
+	stored := make(map[assemble.AssembleType]uint64, len(s.objectStorages))
+	for t, storage := range s.objectStorages {
+		stored[t] = storage.stored.Load()
+	}
+	return stored
+}
+
+type countingObjectStorage struct {
+	AssembleObjectStorage
+
+	stored atomic.Uint64
+}
+
+func (s *countingObjectStorage) Store(ctx context.Context, asm container.Assembly) error {
+	if err := s.AssembleObjectStorage.Store(ctx, asm); err != nil {
+		return err
+	}
+	s.stored.Add(1)
+	return nil
+}
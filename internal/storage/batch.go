@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Batch is a transaction packets are stored in together.
+// Statements are prepared once per batch and reused for every packet.
+type Batch struct {
+	tx    *sqlx.Tx
+	stmts map[string]*sqlx.NamedStmt
+
+	// onCommit and onRollback keep state outside the database
+	// in line with the outcome of the batch.
+	onCommit   []func()
+	onRollback []func()
+}
+
+func newBatch(ctx context.Context, db *sqlx.DB) (*Batch, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "batch: beginning transaction")
+	}
+
+	return &Batch{tx: tx, stmts: make(map[string]*sqlx.NamedStmt)}, nil
+}
+
+// NamedExecContext executes named query with arg within the batch.
+func (b *Batch) NamedExecContext(ctx context.Context, query string, arg any) (sql.Result, error) {
+	stmt, ok := b.stmts[query]
+	if !ok {
+		var err error
+		if stmt, err = b.tx.PrepareNamedContext(ctx, query); err != nil {
+			return nil, errors.Wrap(err, "batch: preparing statement")
+		}
+		b.stmts[query] = stmt
+	}
+
+	return stmt.ExecContext(ctx, arg)
+}
+
+// afterCommit registers fn to be called once the batch is committed.
+func (b *Batch) afterCommit(fn func()) {
+	b.onCommit = append(b.onCommit, fn)
+}
+
+// afterRollback registers fn to be called if the batch is rolled back.
+// Functions are called in reverse order of registration.
+func (b *Batch) afterRollback(fn func()) {
+	b.onRollback = append(b.onRollback, fn)
+}
+
+func (b *Batch) commit() error {
+	b.closeStmts()
+	if err := b.tx.Commit(); err != nil {
+		b.rolledBack()
+		return errors.Wrap(err, "batch: committing")
+	}
+
+	for _, fn := range b.onCommit {
+		fn()
+	}
+	return nil
+}
+
+func (b *Batch) rollback() {
+	b.closeStmts()
+	b.tx.Rollback()
+	b.rolledBack()
+}
+
+func (b *Batch) rolledBack() {
+	for i := len(b.onRollback) - 1; i >= 0; i-- {
+		b.onRollback[i]()
+	}
+}
+
+func (b *Batch) closeStmts() {
+	for _, stmt := range b.stmts {
+		stmt.Close()
+	}
+}
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -17,7 +19,9 @@ func NewCaptureStorage(path string) (*CaptureStorage, error) {
 		path: path,
 	}
 
-	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s/captured.db", path))
+	// WAL lets batches commit without blocking readers,
+	// and synchronous=NORMAL only syncs on checkpoints.
+	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s/captured.db?_journal_mode=WAL&_synchronous=NORMAL", path))
 	if err != nil {
 		return nil, errors.Wrap(err, "capture storage: opening db")
 	}
@@ -30,3 +34,35 @@ func NewCaptureStorage(path string) (*CaptureStorage, error) {
 
 	return storage, nil
 }
+
+// Size returns the total size of the database files
+// and the pcapng files in bytes.
+func (s *CaptureStorage) Size() (uint64, error) {
+	files, err := filepath.Glob(filepath.Join(s.path, "captured.db*"))
+	if err != nil {
+		return 0, errors.Wrap(err, "capture storage: listing db files")
+	}
+
+	pcapngFiles, err := filepath.Glob(filepath.Join(s.path, "pcapng", "*"))
+	if err != nil {
+		return 0, errors.Wrap(err, "capture storage: listing pcapng files")
+	}
+	files = append(files, pcapngFiles...)
+
+	var size uint64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, errors.Wrap(err, "capture storage: stat file")
+		}
+		size += uint64(info.Size())
+	}
+	return size, nil
+}
+
+func (s *CaptureStorage) Close() error {
+	return s.db.Close()
+}
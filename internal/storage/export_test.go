@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/onee-only/netrat/internal/container"
+)
+
+// NewUnbatchedCaptureStorage opens the capture database as it was
+// before batching, in the default journal mode with full sync.
+func NewUnbatchedCaptureStorage(path string) (*CaptureStorage, error) {
+	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s/captured.db", path))
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureStorage{path: path, db: db}, nil
+}
+
+// StoreUnbatched stores packet as PacketStorage did before batching.
+// It writes synchronously, and the packet row and the rows of each
+// layer are committed on their own.
+func (s *PacketStorage) StoreUnbatched(ctx context.Context, packet container.Packet) error {
+	iface := sql.NullString{String: packet.Interface, Valid: packet.Interface != ""}
+
+	_, err := s.db.ExecContext(ctx, "INSERT INTO packet VALUES(?, ?, ?)",
+		packet.ID[:], packet.Metadata().Timestamp, iface)
+	if err != nil {
+		return err
+	}
+
+	for t, storage := range s.layerStorages {
+		if layer := packet.Layer(t); layer == nil {
+			continue
+		}
+
+		// layer storages write to a batch only. a batch for each layer
+		// commits each row on its own, as long as the layer has a single row.
+		b, err := newBatch(ctx, s.db)
+		if err != nil {
+			return err
+		}
+		if err := storage.Store(ctx, b, packet); err != nil {
+			b.rollback()
+			return err
+		}
+		if err := b.commit(); err != nil {
+			return err
+		}
+		s.stored[t].Add(1)
+	}
+	return nil
+}
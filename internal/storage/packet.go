@@ -2,10 +2,12 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
-	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/onee-only/netrat/internal/container"
 	"github.com/pkg/errors"
@@ -13,26 +15,63 @@ import (
 
 type LayerStorage interface {
 	Init(db *sqlx.DB) error
-	Store(ctx context.Context, packet container.Packet) error
+	Store(ctx context.Context, b *Batch, packet container.Packet) error
 }
 
+// RawStorage stores every packet regardless of its layers,
+// within the batch the packet is committed in.
+type RawStorage interface {
+	Store(ctx context.Context, b *Batch, packet container.Packet) error
+}
+
+// PacketStorageOptions configures how packets are batched.
+type PacketStorageOptions struct {
+	// BatchSize is the number of packets committed in one transaction.
+	BatchSize int
+	// FlushInterval is how long packets wait before being committed
+	// when the batch does not fill up.
+	FlushInterval time.Duration
+}
+
+// PacketStorage writes packets in batches from its own goroutine.
+// Store only queues the packet, and errors of the writes
+// are returned by the following Store and Close.
 type PacketStorage struct {
-	db *sqlx.DB
+	db   *sqlx.DB
+	opts PacketStorageOptions
 
 	layerStorages map[gopacket.LayerType]LayerStorage
+	rawStorages   []RawStorage
+	stored        map[gopacket.LayerType]*atomic.Uint64
+
+	onCommit []func() error
+
+	queue   chan container.Packet
+	start   sync.Once
+	started bool
+	done    chan struct{}
+
+	err  error
+	lock sync.Mutex
 }
 
-func NewPacketStorage(capStorage *CaptureStorage) (*PacketStorage, error) {
+func NewPacketStorage(capStorage *CaptureStorage, opts PacketStorageOptions) (*PacketStorage, error) {
 	storage := &PacketStorage{
-		db: capStorage.db,
+		db:   capStorage.db,
+		opts: opts,
 
 		layerStorages: make(map[gopacket.LayerType]LayerStorage),
+		stored:        make(map[gopacket.LayerType]*atomic.Uint64),
+
+		queue: make(chan container.Packet, opts.BatchSize),
+		done:  make(chan struct{}),
 	}
 
 	_, err := storage.db.Exec(`
 		CREATE TABLE packet(
 			id BLOB NOT NULL PRIMARY KEY, 
 			timestamp DATETIME NOT NULL,
+			interface TEXT,
 			UNIQUE(id, timestamp),
 			FOREIGN KEY(id) REFERENCES packet(id)
 		)`)
@@ -43,39 +82,182 @@ func NewPacketStorage(capStorage *CaptureStorage) (*PacketStorage, error) {
 	return storage, nil
 }
 
+// Register must be called before the first Store.
 func (s *PacketStorage) Register(t gopacket.LayerType, storage LayerStorage) error {
 	if err := storage.Init(s.db); err != nil {
 		return errors.Wrap(err, "packet storage: layer storage init")
 	}
 
 	s.layerStorages[t] = storage
+	s.stored[t] = new(atomic.Uint64)
 
 	return nil
 }
 
+// RegisterRaw must be called before the first Store.
+// Raw storages are called from the writer goroutine only.
+func (s *PacketStorage) RegisterRaw(storage RawStorage) {
+	s.rawStorages = append(s.rawStorages, storage)
+}
+
+// OnCommit registers fn to be called from the writer goroutine after
+// each committed batch. Error of fn fails the storage as a write does.
+// It must be called before the first Store.
+func (s *PacketStorage) OnCommit(fn func() error) {
+	s.onCommit = append(s.onCommit, fn)
+}
+
+// Store queues packet to be written with the next batch.
 func (s *PacketStorage) Store(ctx context.Context, packet container.Packet) error {
-	if err := s.storeMetadata(ctx, packet.ID, packet.Metadata().Timestamp); err != nil {
+	if err := s.writeErr(); err != nil {
 		return err
 	}
 
-	for t, storage := range s.layerStorages {
-		if layer := packet.Layer(t); layer != nil {
-			if err := storage.Store(ctx, packet); err != nil {
+	s.start.Do(func() {
+		s.started = true
+		go s.write()
+	})
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case s.queue <- packet:
+		return nil
+	}
+}
+
+// write commits queued packets when the batch is full or
+// FlushInterval has passed, until the queue is closed.
+func (s *PacketStorage) write() {
+	defer close(s.done)
+
+	t := time.NewTicker(s.opts.FlushInterval)
+	defer t.Stop()
+
+	batch := make([]container.Packet, 0, s.opts.BatchSize)
+	flush := func() {
+		// packets after failed batch are dropped.
+		if len(batch) > 0 && s.writeErr() == nil {
+			if err := s.flush(batch); err != nil {
+				s.lock.Lock()
+				s.err = err
+				s.lock.Unlock()
+			}
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case packet, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, packet)
+			if len(batch) >= s.opts.BatchSize {
+				flush()
+			}
+		case <-t.C:
+			flush()
+		}
+	}
+}
+
+func (s *PacketStorage) flush(packets []container.Packet) error {
+	// queued packets are written even after the worker is canceled.
+	ctx := context.Background()
+
+	b, err := newBatch(ctx, s.db)
+	if err != nil {
+		return errors.Wrap(err, "packet storage: starting batch")
+	}
+
+	stored := make(map[gopacket.LayerType]uint64, len(s.layerStorages))
+	for _, packet := range packets {
+		if err := s.storeMetadata(ctx, b, packet); err != nil {
+			b.rollback()
+			return err
+		}
+
+		for _, storage := range s.rawStorages {
+			if err := storage.Store(ctx, b, packet); err != nil {
+				b.rollback()
 				return err
 			}
 		}
+
+		for t, storage := range s.layerStorages {
+			if layer := packet.Layer(t); layer != nil {
+				if err := storage.Store(ctx, b, packet); err != nil {
+					b.rollback()
+					return err
+				}
+				stored[t]++
+			}
+		}
+	}
+
+	if err := b.commit(); err != nil {
+		return errors.Wrap(err, "packet storage: committing batch")
+	}
+
+	for t, n := range stored {
+		s.stored[t].Add(n)
+	}
+
+	for _, fn := range s.onCommit {
+		if err := fn(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (s *PacketStorage) storeMetadata(ctx context.Context, id uuid.UUID, timestamp time.Time) error {
-	_, err := s.db.ExecContext(ctx, "INSERT INTO packet VALUES(?, ?)", id[:], timestamp)
+func (s *PacketStorage) storeMetadata(ctx context.Context, b *Batch, packet container.Packet) error {
+	schema := struct {
+		ID        []byte         `db:"id"`
+		Timestamp time.Time      `db:"timestamp"`
+		Interface sql.NullString `db:"interface"`
+	}{
+		ID:        packet.ID[:],
+		Timestamp: packet.Metadata().Timestamp,
+		Interface: sql.NullString{String: packet.Interface, Valid: packet.Interface != ""},
+	}
+
+	_, err := b.NamedExecContext(ctx, "INSERT INTO packet VALUES(:id, :timestamp, :interface)", schema)
 	if err != nil {
 		return errors.Wrap(err, "packet storage: inserting packet")
 	}
 	return nil
 }
 
+func (s *PacketStorage) writeErr() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.err
+}
+
+// Stored returns the number of committed packets for each registered layer.
+func (s *PacketStorage) Stored() map[gopacket.LayerType]uint64 {
+	stored := make(map[gopacket.LayerType]uint64, len(s.stored))
+	for t, cnt := range s.stored {
+		stored[t] = cnt.Load()
+	}
+	return stored
+}
+
+// Close commits queued packets and closes the database.
 func (s *PacketStorage) Close() error {
-	return s.db.Close()
+	close(s.queue)
+	if s.started {
+		<-s.done
+	}
+
+	werr := s.writeErr()
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	return werr
 }
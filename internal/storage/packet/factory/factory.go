@@ -19,6 +19,14 @@ func New(t gopacket.LayerType) storage.LayerStorage {
 		return &layer.IPv6Storage{}
 	case layers.LayerTypeDNS:
 		return &layer.DNSStorage{}
+	case layers.LayerTypeEthernet:
+		return &layer.EthernetStorage{}
+	case layers.LayerTypeARP:
+		return &layer.ARPStorage{}
+	case layers.LayerTypeICMPv4:
+		return &layer.ICMPv4Storage{}
+	case layers.LayerTypeICMPv6:
+		return &layer.ICMPv6Storage{}
 	}
 
 	return nil
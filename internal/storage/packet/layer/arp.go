@@ -0,0 +1,80 @@
+package layer
+
+import (
+	"context"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/onee-only/netrat/internal/container"
+	"github.com/onee-only/netrat/internal/storage"
+	"github.com/pkg/errors"
+)
+
+const arpTable = `
+CREATE TABLE arp(
+	id BLOB PRIMARY KEY NOT NULL,
+	hw_type INT NOT NULL,
+	proto_type INT NOT NULL,
+	hw_size INT NOT NULL,
+	proto_size INT NOT NULL,
+	operation INT NOT NULL,
+
+	src_hw BLOB NOT NULL, src_proto BLOB NOT NULL,
+	dst_hw BLOB NOT NULL, dst_proto BLOB NOT NULL
+)`
+
+type ARPStorage struct{}
+
+var _ storage.LayerStorage = (*ARPStorage)(nil)
+
+func (s *ARPStorage) Init(db *sqlx.DB) error {
+	_, err := db.Exec(arpTable)
+	if err != nil {
+		return errors.Wrap(err, "arp storage: creating arp table")
+	}
+	return nil
+}
+
+func (s *ARPStorage) Store(ctx context.Context, b *storage.Batch, packet container.Packet) error {
+	arp := packet.Layer(layers.LayerTypeARP).(*layers.ARP)
+	schema := arpToSchema(packet.ID, arp)
+
+	_, err := b.NamedExecContext(ctx,
+		`INSERT INTO arp VALUES(
+			:id, :hw_type, :proto_type, :hw_size, :proto_size, :operation,
+			:src_hw, :src_proto, :dst_hw, :dst_proto
+		)`, schema)
+	if err != nil {
+		return errors.Wrap(err, "arp storage: inserting arp packet")
+	}
+
+	return nil
+}
+
+type ARPSchema struct {
+	ID        []byte `db:"id"`
+	HwType    uint16 `db:"hw_type"`
+	ProtoType uint16 `db:"proto_type"`
+	HwSize    uint8  `db:"hw_size"`
+	ProtoSize uint8  `db:"proto_size"`
+	Operation uint16 `db:"operation"`
+
+	SrcHw    []byte `db:"src_hw"`
+	SrcProto []byte `db:"src_proto"`
+	DstHw    []byte `db:"dst_hw"`
+	DstProto []byte `db:"dst_proto"`
+}
+
+func arpToSchema(id uuid.UUID, arp *layers.ARP) (schema *ARPSchema) {
+	return &ARPSchema{
+		ID:        id[:],
+		HwType:    uint16(arp.AddrType),
+		ProtoType: uint16(arp.Protocol),
+		HwSize:    arp.HwAddressSize,
+		ProtoSize: arp.ProtAddressSize,
+		Operation: arp.Operation,
+		SrcHw:     arp.SourceHwAddress, SrcProto: arp.SourceProtAddress,
+		DstHw: arp.DstHwAddress, DstProto: arp.DstProtAddress,
+	}
+}
@@ -46,7 +46,7 @@ CREATE TABLE dns_record(
 	rdata BLOB NOT NULL
 )`
 
-type DNSStorage struct{ db *sqlx.DB }
+type DNSStorage struct{}
 
 var _ storage.LayerStorage = (*DNSStorage)(nil)
 
@@ -61,11 +61,10 @@ func (s *DNSStorage) Init(db *sqlx.DB) error {
 		return errors.Wrap(err, "dns storage: creating dns_record table")
 	}
 
-	s.db = db
 	return nil
 }
 
-func (s *DNSStorage) Store(ctx context.Context, packet container.Packet) error {
+func (s *DNSStorage) Store(ctx context.Context, b *storage.Batch, packet container.Packet) error {
 	dns := packet.ApplicationLayer().(*layers.DNS)
 	headerSchema := dnsHeaderToSchema(packet.ID, dns)
 
@@ -73,7 +72,7 @@ func (s *DNSStorage) Store(ctx context.Context, packet container.Packet) error {
 		dns.Questions = append(dns.Questions, layers.DNSQuestion{})
 	}
 
-	_, err := s.db.NamedExecContext(ctx,
+	_, err := b.NamedExecContext(ctx,
 		`INSERT INTO dns_header VALUES(
 			:id, :tx_id, :qr, :op_code, 
 			:aa, :tc, :rd, :ra, :z, :res_code, 
@@ -86,19 +85,19 @@ func (s *DNSStorage) Store(ctx context.Context, packet container.Packet) error {
 
 	for _, record := range dns.Answers {
 		schema := dnsRecordToSchema(packet.ID, sectionTypeAnswer, &record)
-		if err := insertDNSRecord(ctx, s.db, schema); err != nil {
+		if err := insertDNSRecord(ctx, b, schema); err != nil {
 			return err
 		}
 	}
 	for _, record := range dns.Authorities {
 		schema := dnsRecordToSchema(packet.ID, sectionTypeAuthority, &record)
-		if err := insertDNSRecord(ctx, s.db, schema); err != nil {
+		if err := insertDNSRecord(ctx, b, schema); err != nil {
 			return err
 		}
 	}
 	for _, record := range dns.Additionals {
 		schema := dnsRecordToSchema(packet.ID, sectionTypeAdditional, &record)
-		if err := insertDNSRecord(ctx, s.db, schema); err != nil {
+		if err := insertDNSRecord(ctx, b, schema); err != nil {
 			return err
 		}
 	}
@@ -138,8 +137,8 @@ type DNSRecordSchema struct {
 	RData   []byte `db:"rdata"`
 }
 
-func insertDNSRecord(ctx context.Context, db *sqlx.DB, schema *DNSRecordSchema) error {
-	_, err := db.NamedExecContext(ctx,
+func insertDNSRecord(ctx context.Context, b *storage.Batch, schema *DNSRecordSchema) error {
+	_, err := b.NamedExecContext(ctx,
 		`INSERT INTO dns_record VALUES(
 			:id, :section,:name, :type, 
 			:class, :ttl, :datalen, :rdata
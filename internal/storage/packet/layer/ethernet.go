@@ -0,0 +1,121 @@
+package layer
+
+import (
+	"context"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/onee-only/netrat/internal/container"
+	"github.com/onee-only/netrat/internal/storage"
+	"github.com/onee-only/netrat/pkg/util"
+	"github.com/pkg/errors"
+)
+
+const ethernetTable = `
+CREATE TABLE ethernet(
+	id BLOB PRIMARY KEY NOT NULL,
+	src BLOB NOT NULL, dst BLOB NOT NULL,
+	ethertype INT NOT NULL,
+	length INT NOT NULL
+)`
+
+// dot1qTable holds the VLAN tags of ethernet frame.
+// Outermost tag has idx 0.
+const dot1qTable = `
+CREATE TABLE dot1q(
+	id BLOB NOT NULL,
+	idx INT NOT NULL,
+	priority INT NOT NULL,
+	drop_eligible INT2 NOT NULL,
+	vlan_id INT NOT NULL,
+	type INT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS dot1q_id ON dot1q(id)`
+
+type EthernetStorage struct{}
+
+var _ storage.LayerStorage = (*EthernetStorage)(nil)
+
+func (s *EthernetStorage) Init(db *sqlx.DB) error {
+	_, err := db.Exec(ethernetTable)
+	if err != nil {
+		return errors.Wrap(err, "ethernet storage: creating ethernet table")
+	}
+
+	_, err = db.Exec(dot1qTable)
+	if err != nil {
+		return errors.Wrap(err, "ethernet storage: creating dot1q table")
+	}
+
+	return nil
+}
+
+func (s *EthernetStorage) Store(ctx context.Context, b *storage.Batch, packet container.Packet) error {
+	eth := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	schema := ethernetToSchema(packet.ID, eth)
+
+	_, err := b.NamedExecContext(ctx,
+		`INSERT INTO ethernet VALUES(
+			:id, :src, :dst, :ethertype, :length
+		)`, schema)
+	if err != nil {
+		return errors.Wrap(err, "ethernet storage: inserting ethernet packet")
+	}
+
+	var idx uint8
+	for _, layer := range packet.Layers() {
+		dot1q, ok := layer.(*layers.Dot1Q)
+		if !ok {
+			continue
+		}
+
+		_, err := b.NamedExecContext(ctx,
+			`INSERT INTO dot1q VALUES(
+				:id, :idx, :priority, :drop_eligible, :vlan_id, :type
+			)`, dot1qToSchema(packet.ID, idx, dot1q))
+		if err != nil {
+			return errors.Wrap(err, "ethernet storage: inserting dot1q tag")
+		}
+		idx++
+	}
+
+	return nil
+}
+
+type EthernetSchema struct {
+	ID        []byte `db:"id"`
+	Src       []byte `db:"src"`
+	Dst       []byte `db:"dst"`
+	EtherType uint16 `db:"ethertype"`
+	Length    uint16 `db:"length"`
+}
+
+type Dot1QSchema struct {
+	ID           []byte `db:"id"`
+	Idx          uint8  `db:"idx"`
+	Priority     uint8  `db:"priority"`
+	DropEligible uint8  `db:"drop_eligible"`
+	VLANID       uint16 `db:"vlan_id"`
+	Type         uint16 `db:"type"`
+}
+
+func ethernetToSchema(id uuid.UUID, eth *layers.Ethernet) (schema *EthernetSchema) {
+	return &EthernetSchema{
+		ID:  id[:],
+		Src: eth.SrcMAC, Dst: eth.DstMAC,
+		EtherType: uint16(eth.EthernetType),
+		Length:    eth.Length,
+	}
+}
+
+func dot1qToSchema(id uuid.UUID, idx uint8, dot1q *layers.Dot1Q) (schema *Dot1QSchema) {
+	return &Dot1QSchema{
+		ID:           id[:],
+		Idx:          idx,
+		Priority:     dot1q.Priority,
+		DropEligible: util.BoolToUint8(dot1q.DropEligible),
+		VLANID:       dot1q.VLANIdentifier,
+		Type:         uint16(dot1q.Type),
+	}
+}
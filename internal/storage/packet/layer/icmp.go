@@ -0,0 +1,61 @@
+package layer
+
+import (
+	"database/sql"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// OriginalDatagram is the header of the datagram quoted by icmp error.
+// Fields are null for icmp messages other than errors, and
+// ports are null unless the datagram is tcp or udp.
+type OriginalDatagram struct {
+	Src      []byte        `db:"orig_src"`
+	Dst      []byte        `db:"orig_dst"`
+	Protocol sql.NullInt32 `db:"orig_protocol"`
+	SrcPort  sql.NullInt32 `db:"orig_src_port"`
+	DstPort  sql.NullInt32 `db:"orig_dst_port"`
+}
+
+const originalDatagramColumns = `
+	orig_src BLOB, orig_dst BLOB,
+	orig_protocol INT,
+	orig_src_port INT, orig_dst_port INT`
+
+// parseOriginalDatagram parses the quoted datagram, which is
+// usually truncated to the ip header and 8 bytes of its payload.
+func parseOriginalDatagram(data []byte, network gopacket.LayerType) (d OriginalDatagram) {
+	var (
+		proto   layers.IPProtocol
+		payload []byte
+	)
+
+	switch network {
+	case layers.LayerTypeIPv4:
+		var ip layers.IPv4
+		if err := ip.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+			return
+		}
+		d.Src, d.Dst = ip.SrcIP, ip.DstIP
+		proto, payload = ip.Protocol, ip.Payload
+	case layers.LayerTypeIPv6:
+		var ip layers.IPv6
+		if err := ip.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+			return
+		}
+		d.Src, d.Dst = ip.SrcIP, ip.DstIP
+		proto, payload = ip.NextHeader, ip.Payload
+	default:
+		return
+	}
+
+	d.Protocol = sql.NullInt32{Int32: int32(proto), Valid: true}
+
+	if (proto == layers.IPProtocolTCP || proto == layers.IPProtocolUDP) && len(payload) >= 4 {
+		d.SrcPort = sql.NullInt32{Int32: int32(payload[0])<<8 | int32(payload[1]), Valid: true}
+		d.DstPort = sql.NullInt32{Int32: int32(payload[2])<<8 | int32(payload[3]), Valid: true}
+	}
+
+	return
+}
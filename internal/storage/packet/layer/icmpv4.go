@@ -0,0 +1,87 @@
+package layer
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/onee-only/netrat/internal/container"
+	"github.com/onee-only/netrat/internal/storage"
+	"github.com/pkg/errors"
+)
+
+const icmpv4Table = `
+CREATE TABLE icmpv4(
+	id BLOB PRIMARY KEY NOT NULL,
+	type INT NOT NULL, code INT NOT NULL,
+	checksum INT NOT NULL,
+	ident INT NOT NULL, seq INT NOT NULL,
+	mtu INT,` + originalDatagramColumns + `
+)`
+
+type ICMPv4Storage struct{}
+
+var _ storage.LayerStorage = (*ICMPv4Storage)(nil)
+
+func (s *ICMPv4Storage) Init(db *sqlx.DB) error {
+	_, err := db.Exec(icmpv4Table)
+	if err != nil {
+		return errors.Wrap(err, "icmpv4 storage: creating icmpv4 table")
+	}
+	return nil
+}
+
+func (s *ICMPv4Storage) Store(ctx context.Context, b *storage.Batch, packet container.Packet) error {
+	icmp := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+	schema := icmpv4ToSchema(packet.ID, icmp)
+
+	_, err := b.NamedExecContext(ctx,
+		`INSERT INTO icmpv4 VALUES(
+			:id, :type, :code, :checksum, :ident, :seq, :mtu,
+			:orig_src, :orig_dst, :orig_protocol, :orig_src_port, :orig_dst_port
+		)`, schema)
+	if err != nil {
+		return errors.Wrap(err, "icmpv4 storage: inserting icmpv4 packet")
+	}
+
+	return nil
+}
+
+type ICMPv4Schema struct {
+	ID       []byte `db:"id"`
+	Type     uint8  `db:"type"`
+	Code     uint8  `db:"code"`
+	Checksum uint16 `db:"checksum"`
+	Ident    uint16 `db:"ident"`
+	Seq      uint16 `db:"seq"`
+
+	// MTU is the next hop mtu of fragmentation needed message.
+	MTU sql.NullInt32 `db:"mtu"`
+
+	OriginalDatagram
+}
+
+func icmpv4ToSchema(id uuid.UUID, icmp *layers.ICMPv4) (schema *ICMPv4Schema) {
+	schema = &ICMPv4Schema{
+		ID:       id[:],
+		Type:     icmp.TypeCode.Type(),
+		Code:     icmp.TypeCode.Code(),
+		Checksum: icmp.Checksum,
+		Ident:    icmp.Id, Seq: icmp.Seq,
+	}
+
+	switch schema.Type {
+	case layers.ICMPv4TypeDestinationUnreachable:
+		if schema.Code == layers.ICMPv4CodeFragmentationNeeded {
+			schema.MTU = sql.NullInt32{Int32: int32(icmp.Seq), Valid: true}
+		}
+		fallthrough
+	case layers.ICMPv4TypeSourceQuench, layers.ICMPv4TypeRedirect,
+		layers.ICMPv4TypeTimeExceeded, layers.ICMPv4TypeParameterProblem:
+		schema.OriginalDatagram = parseOriginalDatagram(icmp.Payload, layers.LayerTypeIPv4)
+	}
+
+	return schema
+}
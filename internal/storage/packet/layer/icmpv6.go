@@ -0,0 +1,188 @@
+package layer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/onee-only/netrat/internal/container"
+	"github.com/onee-only/netrat/internal/storage"
+	"github.com/pkg/errors"
+)
+
+const icmpv6Table = `
+CREATE TABLE icmpv6(
+	id BLOB PRIMARY KEY NOT NULL,
+	type INT NOT NULL, code INT NOT NULL,
+	checksum INT NOT NULL,
+	ident INT, seq INT,
+	mtu INT,` + originalDatagramColumns + `
+)`
+
+// ndpTable holds neighbor discovery messages.
+// target is null for router messages, and link_addr is
+// the link-layer address option of the message if any.
+const ndpTable = `
+CREATE TABLE ndp(
+	id BLOB PRIMARY KEY NOT NULL,
+	type INT NOT NULL,
+	flags INT,
+	target BLOB, destination BLOB,
+	link_addr BLOB
+)`
+
+type ICMPv6Storage struct{}
+
+var _ storage.LayerStorage = (*ICMPv6Storage)(nil)
+
+func (s *ICMPv6Storage) Init(db *sqlx.DB) error {
+	_, err := db.Exec(icmpv6Table)
+	if err != nil {
+		return errors.Wrap(err, "icmpv6 storage: creating icmpv6 table")
+	}
+
+	_, err = db.Exec(ndpTable)
+	if err != nil {
+		return errors.Wrap(err, "icmpv6 storage: creating ndp table")
+	}
+
+	return nil
+}
+
+func (s *ICMPv6Storage) Store(ctx context.Context, b *storage.Batch, packet container.Packet) error {
+	icmp := packet.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6)
+	schema := icmpv6ToSchema(packet.ID, icmp)
+
+	if echo, ok := packet.Layer(layers.LayerTypeICMPv6Echo).(*layers.ICMPv6Echo); ok {
+		schema.Ident = sql.NullInt32{Int32: int32(echo.Identifier), Valid: true}
+		schema.Seq = sql.NullInt32{Int32: int32(echo.SeqNumber), Valid: true}
+	}
+
+	_, err := b.NamedExecContext(ctx,
+		`INSERT INTO icmpv6 VALUES(
+			:id, :type, :code, :checksum, :ident, :seq, :mtu,
+			:orig_src, :orig_dst, :orig_protocol, :orig_src_port, :orig_dst_port
+		)`, schema)
+	if err != nil {
+		return errors.Wrap(err, "icmpv6 storage: inserting icmpv6 packet")
+	}
+
+	ndp := ndpToSchema(packet)
+	if ndp == nil {
+		return nil
+	}
+
+	_, err = b.NamedExecContext(ctx,
+		`INSERT INTO ndp VALUES(
+			:id, :type, :flags, :target, :destination, :link_addr
+		)`, ndp)
+	if err != nil {
+		return errors.Wrap(err, "icmpv6 storage: inserting ndp message")
+	}
+
+	return nil
+}
+
+type ICMPv6Schema struct {
+	ID       []byte        `db:"id"`
+	Type     uint8         `db:"type"`
+	Code     uint8         `db:"code"`
+	Checksum uint16        `db:"checksum"`
+	Ident    sql.NullInt32 `db:"ident"`
+	Seq      sql.NullInt32 `db:"seq"`
+
+	// MTU is the mtu of packet too big message.
+	MTU sql.NullInt32 `db:"mtu"`
+
+	OriginalDatagram
+}
+
+type NDPSchema struct {
+	ID          []byte        `db:"id"`
+	Type        uint8         `db:"type"`
+	Flags       sql.NullInt32 `db:"flags"`
+	Target      []byte        `db:"target"`
+	Destination []byte        `db:"destination"`
+	LinkAddr    []byte        `db:"link_addr"`
+}
+
+func icmpv6ToSchema(id uuid.UUID, icmp *layers.ICMPv6) (schema *ICMPv6Schema) {
+	schema = &ICMPv6Schema{
+		ID:       id[:],
+		Type:     icmp.TypeCode.Type(),
+		Code:     icmp.TypeCode.Code(),
+		Checksum: icmp.Checksum,
+	}
+
+	// error messages have 4 bytes of message specific data
+	// before the quoted datagram.
+	switch schema.Type {
+	case layers.ICMPv6TypePacketTooBig:
+		if len(icmp.Payload) >= 4 {
+			mtu := binary.BigEndian.Uint32(icmp.Payload)
+			schema.MTU = sql.NullInt32{Int32: int32(mtu), Valid: true}
+		}
+		fallthrough
+	case layers.ICMPv6TypeDestinationUnreachable, layers.ICMPv6TypeTimeExceeded,
+		layers.ICMPv6TypeParameterProblem:
+		if len(icmp.Payload) >= 4 {
+			schema.OriginalDatagram = parseOriginalDatagram(icmp.Payload[4:], layers.LayerTypeIPv6)
+		}
+	}
+
+	return schema
+}
+
+// ndpToSchema returns nil if packet is not neighbor discovery message.
+func ndpToSchema(packet container.Packet) *NDPSchema {
+	for _, layer := range packet.Layers() {
+		if schema := ndpLayerToSchema(packet.ID, layer); schema != nil {
+			return schema
+		}
+	}
+	return nil
+}
+
+func ndpLayerToSchema(id uuid.UUID, layer gopacket.Layer) (schema *NDPSchema) {
+	schema = &NDPSchema{ID: id[:]}
+
+	var opts layers.ICMPv6Options
+	switch l := layer.(type) {
+	case *layers.ICMPv6RouterSolicitation:
+		schema.Type = layers.ICMPv6TypeRouterSolicitation
+		opts = l.Options
+	case *layers.ICMPv6RouterAdvertisement:
+		schema.Type = layers.ICMPv6TypeRouterAdvertisement
+		schema.Flags = sql.NullInt32{Int32: int32(l.Flags), Valid: true}
+		opts = l.Options
+	case *layers.ICMPv6NeighborSolicitation:
+		schema.Type = layers.ICMPv6TypeNeighborSolicitation
+		schema.Target = l.TargetAddress
+		opts = l.Options
+	case *layers.ICMPv6NeighborAdvertisement:
+		schema.Type = layers.ICMPv6TypeNeighborAdvertisement
+		schema.Flags = sql.NullInt32{Int32: int32(l.Flags), Valid: true}
+		schema.Target = l.TargetAddress
+		opts = l.Options
+	case *layers.ICMPv6Redirect:
+		schema.Type = layers.ICMPv6TypeRedirect
+		schema.Target = l.TargetAddress
+		schema.Destination = l.DestinationAddress
+		opts = l.Options
+	default:
+		return nil
+	}
+
+	for _, opt := range opts {
+		if opt.Type == layers.ICMPv6OptSourceAddress || opt.Type == layers.ICMPv6OptTargetAddress {
+			schema.LinkAddr = opt.Data
+			break
+		}
+	}
+
+	return schema
+}
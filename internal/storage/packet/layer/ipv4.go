@@ -29,7 +29,19 @@ CREATE TABLE ipv4(
 	src BLOB NOT NULL, dst BLOB NOT NULL
 )`
 
-type IPv4Storage struct{ db *sqlx.DB }
+// ipv4OptionTable holds the options of ipv4 header in order of idx,
+// except for padding.
+const ipv4OptionTable = `
+CREATE TABLE ipv4_option(
+	id BLOB NOT NULL,
+	idx INT NOT NULL,
+	type INT NOT NULL,
+	length INT NOT NULL,
+	data BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ipv4_option_id ON ipv4_option(id)`
+
+type IPv4Storage struct{}
 
 var _ storage.LayerStorage = (*IPv4Storage)(nil)
 
@@ -38,15 +50,20 @@ func (s *IPv4Storage) Init(db *sqlx.DB) error {
 	if err != nil {
 		return errors.Wrap(err, "ipv4 storage: creating ipv4 table")
 	}
-	s.db = db
+
+	_, err = db.Exec(ipv4OptionTable)
+	if err != nil {
+		return errors.Wrap(err, "ipv4 storage: creating ipv4_option table")
+	}
+
 	return nil
 }
 
-func (s *IPv4Storage) Store(ctx context.Context, packet container.Packet) error {
+func (s *IPv4Storage) Store(ctx context.Context, b *storage.Batch, packet container.Packet) error {
 	ipv4 := packet.NetworkLayer().(*layers.IPv4)
 	schema := ipv4ToSchema(packet.ID, ipv4)
 
-	_, err := s.db.NamedExecContext(ctx,
+	_, err := b.NamedExecContext(ctx,
 		`INSERT INTO ipv4 VALUES(
 			:id, :version, :hlen, :tos, :length,
 			:frag_id, :frag_flag, :frag_offset,
@@ -57,6 +74,16 @@ func (s *IPv4Storage) Store(ctx context.Context, packet container.Packet) error
 		return errors.Wrap(err, "ipv4 storage: inserting ipv4 packet")
 	}
 
+	for _, option := range ipv4OptionsToSchema(packet.ID, ipv4.Options) {
+		_, err := b.NamedExecContext(ctx,
+			`INSERT INTO ipv4_option VALUES(
+				:id, :idx, :type, :length, :data
+			)`, option)
+		if err != nil {
+			return errors.Wrap(err, "ipv4 storage: inserting ipv4 option")
+		}
+	}
+
 	return nil
 }
 
@@ -79,6 +106,14 @@ type IPv4Schema struct {
 	Dst []byte `db:"dst"`
 }
 
+type IPv4OptionSchema struct {
+	ID     []byte `db:"id"`
+	Idx    uint8  `db:"idx"`
+	Type   uint8  `db:"type"`
+	Length uint8  `db:"length"`
+	Data   []byte `db:"data"`
+}
+
 func ipv4ToSchema(id uuid.UUID, ipv4 *layers.IPv4) (schema *IPv4Schema) {
 	return &IPv4Schema{
 		ID:         id[:],
@@ -95,3 +130,21 @@ func ipv4ToSchema(id uuid.UUID, ipv4 *layers.IPv4) (schema *IPv4Schema) {
 		Src:        ipv4.SrcIP, Dst: ipv4.DstIP,
 	}
 }
+
+func ipv4OptionsToSchema(id uuid.UUID, options []layers.IPv4Option) (schemas []*IPv4OptionSchema) {
+	for _, opt := range options {
+		// end of options list and no operation.
+		if opt.OptionType == 0 || opt.OptionType == 1 {
+			continue
+		}
+
+		schemas = append(schemas, &IPv4OptionSchema{
+			ID:     id[:],
+			Idx:    uint8(len(schemas)),
+			Type:   opt.OptionType,
+			Length: opt.OptionLength,
+			Data:   opt.OptionData,
+		})
+	}
+	return schemas
+}
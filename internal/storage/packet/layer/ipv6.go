@@ -2,6 +2,10 @@ package layer
 
 import (
 	"context"
+	"database/sql"
+	"encoding/binary"
+	"net"
+	"slices"
 
 	"github.com/google/gopacket/layers"
 	"github.com/google/uuid"
@@ -20,10 +24,50 @@ CREATE TABLE ipv6(
 	length INT NOT NULL,
 	next_header INT NOT NULL, 
 	hop_limit INT NOT NULL,
-	src BLOB NOT NULL, dst BLOB NOT NULL
+	src BLOB NOT NULL, dst BLOB NOT NULL,
+	upper_protocol INT NOT NULL
 )`
 
-type IPv6Storage struct{ db *sqlx.DB }
+// ipv6ExtTable holds the extension header chain in order of idx.
+// type is the protocol number of the header itself,
+// and the rest of the header is kept in data.
+const ipv6ExtTable = `
+CREATE TABLE ipv6_ext(
+	id BLOB NOT NULL,
+	idx INT NOT NULL,
+	type INT NOT NULL,
+	next_header INT NOT NULL,
+	length INT NOT NULL,
+
+	routing_type INT, segments_left INT,
+	frag_offset INT, frag_more INT2, frag_id INT,
+
+	data BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ipv6_ext_id ON ipv6_ext(id)`
+
+// ipv6OptionTable holds the options of hop-by-hop and destination
+// options headers, except for padding.
+const ipv6OptionTable = `
+CREATE TABLE ipv6_option(
+	id BLOB NOT NULL,
+	idx INT NOT NULL, opt_idx INT NOT NULL,
+	type INT NOT NULL,
+	data BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ipv6_option_id ON ipv6_option(id)`
+
+// ipv6SegmentTable holds the addresses of routing headers,
+// such as segment list of SRv6.
+const ipv6SegmentTable = `
+CREATE TABLE ipv6_segment(
+	id BLOB NOT NULL,
+	idx INT NOT NULL, seg_idx INT NOT NULL,
+	addr BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ipv6_segment_id ON ipv6_segment(id)`
+
+type IPv6Storage struct{}
 
 var _ storage.LayerStorage = (*IPv6Storage)(nil)
 
@@ -32,24 +76,72 @@ func (s *IPv6Storage) Init(db *sqlx.DB) error {
 	if err != nil {
 		return errors.Wrap(err, "ipv6 storage: creating ipv6 table")
 	}
-	s.db = db
+
+	for _, table := range []struct{ name, query string }{
+		{"ipv6_ext", ipv6ExtTable},
+		{"ipv6_option", ipv6OptionTable},
+		{"ipv6_segment", ipv6SegmentTable},
+	} {
+		if _, err := db.Exec(table.query); err != nil {
+			return errors.Wrapf(err, "ipv6 storage: creating %s table", table.name)
+		}
+	}
+
 	return nil
 }
 
-func (s *IPv6Storage) Store(ctx context.Context, packet container.Packet) error {
+func (s *IPv6Storage) Store(ctx context.Context, b *storage.Batch, packet container.Packet) error {
 	ipv6 := packet.NetworkLayer().(*layers.IPv6)
 	schema := ipv6ToSchema(packet.ID, ipv6)
+	chain := ipv6ExtChain(packet.ID, ipv6)
+
+	schema.UpperProtocol = schema.NextHeader
+	if len(chain) > 0 {
+		schema.UpperProtocol = chain[len(chain)-1].ext.NextHeader
+	}
 
-	_, err := s.db.NamedExecContext(ctx,
+	_, err := b.NamedExecContext(ctx,
 		`INSERT INTO ipv6 VALUES(
 			:id, :version, :priority, :flow_label,
 			:length, :next_header, :hop_limit,
-			:src, :dst
+			:src, :dst, :upper_protocol
 		)`, schema)
 	if err != nil {
 		return errors.Wrap(err, "ipv6 storage: inserting ipv6 packet")
 	}
 
+	for _, h := range chain {
+		_, err := b.NamedExecContext(ctx,
+			`INSERT INTO ipv6_ext VALUES(
+				:id, :idx, :type, :next_header, :length,
+				:routing_type, :segments_left,
+				:frag_offset, :frag_more, :frag_id, :data
+			)`, h.ext)
+		if err != nil {
+			return errors.Wrap(err, "ipv6 storage: inserting extension header")
+		}
+
+		for _, opt := range h.options {
+			_, err := b.NamedExecContext(ctx,
+				`INSERT INTO ipv6_option VALUES(
+					:id, :idx, :opt_idx, :type, :data
+				)`, opt)
+			if err != nil {
+				return errors.Wrap(err, "ipv6 storage: inserting option")
+			}
+		}
+
+		for _, seg := range h.segments {
+			_, err := b.NamedExecContext(ctx,
+				`INSERT INTO ipv6_segment VALUES(
+					:id, :idx, :seg_idx, :addr
+				)`, seg)
+			if err != nil {
+				return errors.Wrap(err, "ipv6 storage: inserting routing segment")
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -64,6 +156,47 @@ type IPv6Schema struct {
 
 	Src []byte `db:"src"`
 	Dst []byte `db:"dst"`
+
+	// UpperProtocol is the next header of the last extension header.
+	UpperProtocol uint8 `db:"upper_protocol"`
+}
+
+type IPv6ExtSchema struct {
+	ID         []byte `db:"id"`
+	Idx        uint8  `db:"idx"`
+	Type       uint8  `db:"type"`
+	NextHeader uint8  `db:"next_header"`
+	Length     int    `db:"length"`
+
+	RoutingType  sql.NullInt32 `db:"routing_type"`
+	SegmentsLeft sql.NullInt32 `db:"segments_left"`
+
+	FragOffset sql.NullInt32 `db:"frag_offset"`
+	FragMore   sql.NullInt32 `db:"frag_more"`
+	FragID     sql.NullInt64 `db:"frag_id"`
+
+	Data []byte `db:"data"`
+}
+
+type IPv6OptionSchema struct {
+	ID     []byte `db:"id"`
+	Idx    uint8  `db:"idx"`
+	OptIdx uint8  `db:"opt_idx"`
+	Type   uint8  `db:"type"`
+	Data   []byte `db:"data"`
+}
+
+type IPv6SegmentSchema struct {
+	ID     []byte `db:"id"`
+	Idx    uint8  `db:"idx"`
+	SegIdx uint8  `db:"seg_idx"`
+	Addr   []byte `db:"addr"`
+}
+
+type ipv6ExtHeader struct {
+	ext      *IPv6ExtSchema
+	options  []*IPv6OptionSchema
+	segments []*IPv6SegmentSchema
 }
 
 func ipv6ToSchema(id uuid.UUID, ipv6 *layers.IPv6) (schema *IPv6Schema) {
@@ -78,3 +211,122 @@ func ipv6ToSchema(id uuid.UUID, ipv6 *layers.IPv6) (schema *IPv6Schema) {
 		Src:        ipv6.SrcIP, Dst: ipv6.DstIP,
 	}
 }
+
+// ipv6ExtChain parses the extension headers following the fixed header.
+// gopacket does not decode every routing type such as SRv6,
+// so the chain is parsed from the raw headers instead of the layers.
+// Parsing stops at the upper-layer header or at non-first fragment.
+func ipv6ExtChain(id uuid.UUID, ipv6 *layers.IPv6) (chain []ipv6ExtHeader) {
+	next, data := ipv6.NextHeader, ipv6.Payload
+	if ipv6.HopByHop != nil {
+		// hop-by-hop header is consumed by gopacket.
+		data = append(slices.Clip(ipv6.HopByHop.Contents), data...)
+	}
+
+	for {
+		ext := &IPv6ExtSchema{
+			ID:   id[:],
+			Idx:  uint8(len(chain)),
+			Type: uint8(next),
+		}
+
+		var length int
+		switch next {
+		case layers.IPProtocolIPv6HopByHop, layers.IPProtocolIPv6Routing, layers.IPProtocolIPv6Destination:
+			if len(data) < 2 {
+				return chain
+			}
+			length = (int(data[1]) + 1) * 8
+		case layers.IPProtocolIPv6Fragment:
+			length = 8
+		default:
+			return chain
+		}
+
+		if len(data) < length {
+			return chain
+		}
+
+		contents := data[:length]
+		ext.NextHeader = contents[0]
+		ext.Length = length
+		ext.Data = contents
+		h := ipv6ExtHeader{ext: ext}
+
+		switch next {
+		case layers.IPProtocolIPv6HopByHop, layers.IPProtocolIPv6Destination:
+			h.options = ipv6OptionsToSchema(id, ext.Idx, contents[2:])
+		case layers.IPProtocolIPv6Routing:
+			ext.RoutingType = sql.NullInt32{Int32: int32(contents[2]), Valid: true}
+			ext.SegmentsLeft = sql.NullInt32{Int32: int32(contents[3]), Valid: true}
+			h.segments = ipv6RoutingSegments(id, ext.Idx, contents)
+		case layers.IPProtocolIPv6Fragment:
+			offset := binary.BigEndian.Uint16(contents[2:4]) >> 3
+			ext.FragOffset = sql.NullInt32{Int32: int32(offset), Valid: true}
+			ext.FragMore = sql.NullInt32{Int32: int32(contents[3] & 1), Valid: true}
+			ext.FragID = sql.NullInt64{Int64: int64(binary.BigEndian.Uint32(contents[4:8])), Valid: true}
+		}
+
+		chain = append(chain, h)
+
+		if ext.FragOffset.Valid && ext.FragOffset.Int32 != 0 {
+			// rest of the chain is in the first fragment.
+			return chain
+		}
+
+		next, data = layers.IPProtocol(ext.NextHeader), data[length:]
+	}
+}
+
+// ipv6OptionsToSchema parses TLV options of hop-by-hop
+// or destination options header, skipping padding.
+func ipv6OptionsToSchema(id uuid.UUID, idx uint8, data []byte) (options []*IPv6OptionSchema) {
+	for len(data) > 0 {
+		// Pad1 has no length.
+		if data[0] == 0 {
+			data = data[1:]
+			continue
+		}
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return options
+		}
+
+		typ, optData := data[0], data[2:2+int(data[1])]
+		data = data[2+int(data[1]):]
+
+		// PadN.
+		if typ == 1 {
+			continue
+		}
+
+		options = append(options, &IPv6OptionSchema{
+			ID:     id[:],
+			Idx:    idx,
+			OptIdx: uint8(len(options)),
+			Type:   typ,
+			Data:   optData,
+		})
+	}
+	return options
+}
+
+// ipv6RoutingSegments returns the addresses following the first 8 bytes
+// of routing header, which is the layout of type 0, 2 and 4 (SRv6).
+func ipv6RoutingSegments(id uuid.UUID, idx uint8, contents []byte) (segments []*IPv6SegmentSchema) {
+	switch contents[2] {
+	case 0, 2, 4:
+	default:
+		return nil
+	}
+
+	for addrs := contents[8:]; len(addrs) >= net.IPv6len; addrs = addrs[net.IPv6len:] {
+		segments = append(segments, &IPv6SegmentSchema{
+			ID:     id[:],
+			Idx:    idx,
+			SegIdx: uint8(len(segments)),
+			Addr:   addrs[:net.IPv6len],
+		})
+	}
+
+	return segments
+}
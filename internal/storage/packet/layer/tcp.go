@@ -2,7 +2,10 @@ package layer
 
 import (
 	"context"
+	"database/sql"
+	"encoding/binary"
 
+	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
@@ -25,10 +28,29 @@ CREATE TABLE tcp(
 
 	window INT NOT NULL, 
 	checksum INT NOT NULL,
-	urgent INT NOT NULL
+	urgent INT NOT NULL,
+	payload_len INT NOT NULL
 )`
 
-type TCPStorage struct{ db *sqlx.DB }
+// tcpOptionTable holds the options of tcp header in order of idx,
+// except for padding. Known options are decoded into their columns,
+// and SACK option has a row for each block with the same idx.
+const tcpOptionTable = `
+CREATE TABLE tcp_option(
+	id BLOB NOT NULL,
+	idx INT NOT NULL,
+	kind INT NOT NULL,
+
+	mss INT,
+	wscale INT,
+	sack_left INT, sack_right INT,
+	tsval INT, tsecr INT,
+
+	data BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tcp_option_id ON tcp_option(id)`
+
+type TCPStorage struct{}
 
 var _ storage.LayerStorage = (*TCPStorage)(nil)
 
@@ -37,24 +59,41 @@ func (s *TCPStorage) Init(db *sqlx.DB) error {
 	if err != nil {
 		return errors.Wrap(err, "tcp storage: creating tcp table")
 	}
-	s.db = db
+
+	_, err = db.Exec(tcpOptionTable)
+	if err != nil {
+		return errors.Wrap(err, "tcp storage: creating tcp_option table")
+	}
+
 	return nil
 }
 
-func (s *TCPStorage) Store(ctx context.Context, packet container.Packet) error {
+func (s *TCPStorage) Store(ctx context.Context, b *storage.Batch, packet container.Packet) error {
 	tcp := packet.TransportLayer().(*layers.TCP)
 	schema := tcpToSchema(packet.ID, tcp)
+	schema.PayloadLen = tcpPayloadLen(packet.NetworkLayer(), tcp)
 
-	_, err := s.db.NamedExecContext(ctx,
+	_, err := b.NamedExecContext(ctx,
 		`INSERT INTO tcp VALUES(
 			:id, :src, :dst, :seqnum, :acknum, :offset,
 			:fin, :syn, :rst, :psh, :ack, :urg, :ece, :cwr, :ns,
-			:window, :checksum, :urgent
+			:window, :checksum, :urgent, :payload_len
 		)`, schema)
 	if err != nil {
 		return errors.Wrap(err, "tcp storage: inserting tcp packet")
 	}
 
+	for _, option := range tcpOptionsToSchema(packet.ID, tcp.Options) {
+		_, err := b.NamedExecContext(ctx,
+			`INSERT INTO tcp_option VALUES(
+				:id, :idx, :kind, :mss, :wscale,
+				:sack_left, :sack_right, :tsval, :tsecr, :data
+			)`, option)
+		if err != nil {
+			return errors.Wrap(err, "tcp storage: inserting tcp option")
+		}
+	}
+
 	return nil
 }
 
@@ -79,6 +118,26 @@ type TcpSchema struct {
 	Window   uint16 `db:"window"`
 	Checksum uint16 `db:"checksum"`
 	Urgent   uint16 `db:"urgent"`
+
+	// PayloadLen is the length of payload on the wire, computed from the
+	// length in the ip header. It can exceed the captured payload
+	// when the packet is truncated by snaplen.
+	PayloadLen int `db:"payload_len"`
+}
+
+type TCPOptionSchema struct {
+	ID   []byte `db:"id"`
+	Idx  uint8  `db:"idx"`
+	Kind uint8  `db:"kind"`
+
+	MSS       sql.NullInt32 `db:"mss"`
+	WScale    sql.NullInt32 `db:"wscale"`
+	SACKLeft  sql.NullInt64 `db:"sack_left"`
+	SACKRight sql.NullInt64 `db:"sack_right"`
+	TSVal     sql.NullInt64 `db:"tsval"`
+	TSEcr     sql.NullInt64 `db:"tsecr"`
+
+	Data []byte `db:"data"`
 }
 
 func tcpToSchema(id uuid.UUID, tcp *layers.TCP) (schema *TcpSchema) {
@@ -86,22 +145,118 @@ func tcpToSchema(id uuid.UUID, tcp *layers.TCP) (schema *TcpSchema) {
 		ID:  id[:],
 		Src: uint16(tcp.SrcPort), Dst: uint16(tcp.DstPort),
 		Seqnum: tcp.Seq, Acknum: tcp.Ack,
-		Offset:   tcp.DataOffset,
-		Fin:      util.BoolToUint8(tcp.FIN),
-		Syn:      util.BoolToUint8(tcp.SYN),
-		Rst:      util.BoolToUint8(tcp.RST),
-		Psh:      util.BoolToUint8(tcp.PSH),
-		Ack:      util.BoolToUint8(tcp.ACK),
-		Urg:      util.BoolToUint8(tcp.URG),
-		Ece:      util.BoolToUint8(tcp.ECE),
-		Cwr:      util.BoolToUint8(tcp.CWR),
-		Ns:       util.BoolToUint8(tcp.NS),
-		Window:   tcp.Window,
-		Checksum: tcp.Checksum,
-		Urgent:   tcp.Urgent,
+		Offset:     tcp.DataOffset,
+		Fin:        util.BoolToUint8(tcp.FIN),
+		Syn:        util.BoolToUint8(tcp.SYN),
+		Rst:        util.BoolToUint8(tcp.RST),
+		Psh:        util.BoolToUint8(tcp.PSH),
+		Ack:        util.BoolToUint8(tcp.ACK),
+		Urg:        util.BoolToUint8(tcp.URG),
+		Ece:        util.BoolToUint8(tcp.ECE),
+		Cwr:        util.BoolToUint8(tcp.CWR),
+		Ns:         util.BoolToUint8(tcp.NS),
+		Window:     tcp.Window,
+		Checksum:   tcp.Checksum,
+		Urgent:     tcp.Urgent,
+		PayloadLen: len(tcp.Payload),
 	}
 }
 
+// tcpPayloadLen returns the length of tcp payload on the wire. It falls
+// back to the captured length if the ip header does not tell it.
+func tcpPayloadLen(network gopacket.NetworkLayer, tcp *layers.TCP) int {
+	var segmentLen int
+	switch ip := network.(type) {
+	case *layers.IPv4:
+		segmentLen = int(ip.Length) - int(ip.IHL)*4
+	case *layers.IPv6:
+		// length in the header counts extension headers too. they are
+		// in ip.Payload before the tcp header, except for hop-by-hop header
+		// which gopacket takes out unless the packet is a jumbogram.
+		length, jumbo := ipv6PayloadLen(ip)
+		extLen := len(ip.Payload) - len(tcp.Contents) - len(tcp.Payload)
+		if ip.HopByHop != nil && !jumbo {
+			extLen += len(ip.HopByHop.Contents)
+		}
+		segmentLen = length - extLen
+	default:
+		return len(tcp.Payload)
+	}
+
+	n := segmentLen - int(tcp.DataOffset)*4
+	if n < len(tcp.Payload) {
+		// bogus length in the header.
+		return len(tcp.Payload)
+	}
+	return n
+}
+
+// ipv6PayloadLen returns the length of ipv6 payload, which is in
+// the jumbo payload option when the length in the header is zero.
+func ipv6PayloadLen(ip *layers.IPv6) (length int, jumbo bool) {
+	if ip.Length != 0 || ip.HopByHop == nil {
+		return int(ip.Length), false
+	}
+
+	for _, opt := range ip.HopByHop.Options {
+		if opt.OptionType == layers.IPv6HopByHopOptionJumbogram && len(opt.OptionData) == 4 {
+			return int(binary.BigEndian.Uint32(opt.OptionData)), true
+		}
+	}
+	return 0, false
+}
+
+func tcpOptionsToSchema(id uuid.UUID, options []layers.TCPOption) (schemas []*TCPOptionSchema) {
+	var idx uint8
+	for _, opt := range options {
+		if opt.OptionType == layers.TCPOptionKindEndList || opt.OptionType == layers.TCPOptionKindNop {
+			continue
+		}
+
+		schema := &TCPOptionSchema{
+			ID:   id[:],
+			Idx:  idx,
+			Kind: uint8(opt.OptionType),
+			// data of decoded options are kept too,
+			// as they may be malformed.
+			Data: opt.OptionData,
+		}
+		idx++
+
+		data := opt.OptionData
+		switch opt.OptionType {
+		case layers.TCPOptionKindMSS:
+			if len(data) == 2 {
+				schema.MSS = sql.NullInt32{Int32: int32(binary.BigEndian.Uint16(data)), Valid: true}
+			}
+		case layers.TCPOptionKindWindowScale:
+			if len(data) == 1 {
+				schema.WScale = sql.NullInt32{Int32: int32(data[0]), Valid: true}
+			}
+		case layers.TCPOptionKindTimestamps:
+			if len(data) == 8 {
+				schema.TSVal = sql.NullInt64{Int64: int64(binary.BigEndian.Uint32(data)), Valid: true}
+				schema.TSEcr = sql.NullInt64{Int64: int64(binary.BigEndian.Uint32(data[4:])), Valid: true}
+			}
+		case layers.TCPOptionKindSACK:
+			if len(data) == 0 || len(data)%8 != 0 {
+				break
+			}
+			for ; len(data) >= 8; data = data[8:] {
+				block := *schema
+				block.SACKLeft = sql.NullInt64{Int64: int64(binary.BigEndian.Uint32(data)), Valid: true}
+				block.SACKRight = sql.NullInt64{Int64: int64(binary.BigEndian.Uint32(data[4:])), Valid: true}
+				schemas = append(schemas, &block)
+			}
+			continue
+		}
+
+		schemas = append(schemas, schema)
+	}
+
+	return schemas
+}
+
 func _(schema *TcpSchema) (tcp *layers.TCP) {
 	return &layers.TCP{
 		SrcPort: layers.TCPPort(schema.Src), DstPort: layers.TCPPort(schema.Dst),
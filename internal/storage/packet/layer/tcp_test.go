@@ -0,0 +1,100 @@
+package layer
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// ipv6Packet returns an ipv6 packet carrying tcp header and payload,
+// with a hop-by-hop header if hbh is set. Length in the ipv6 header is
+// set as if payloadLen bytes of payload were sent.
+func ipv6Packet(t *testing.T, hbh bool, payload []byte, payloadLen int) gopacket.Packet {
+	t.Helper()
+
+	ip := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolTCP,
+		HopLimit:   64,
+		SrcIP:      net.ParseIP("fe80::1"),
+		DstIP:      net.ParseIP("fe80::2"),
+	}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 80, ACK: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	segment := buf.Bytes()
+
+	var ext []byte
+	if hbh {
+		// next header, length and a PadN option filling 8 bytes.
+		ext = []byte{byte(layers.IPProtocolTCP), 0, 1, 4, 0, 0, 0, 0}
+		ip.NextHeader = layers.IPProtocolIPv6HopByHop
+	}
+
+	header := gopacket.NewSerializeBuffer()
+	if err := ip.SerializeTo(header, gopacket.SerializeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := append(header.Bytes(), ext...)
+	data = append(data, segment...)
+	binary.BigEndian.PutUint16(data[4:], uint16(len(ext)+len(segment)-len(payload)+payloadLen))
+
+	p := gopacket.NewPacket(data, layers.LayerTypeIPv6, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	return p
+}
+
+func TestTCPPayloadLenIPv6(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		hbh        bool
+		captured   int
+		payloadLen int
+	}{
+		{"whole", false, 100, 100},
+		{"truncated", false, 10, 100},
+		{"hop-by-hop", true, 100, 100},
+		{"hop-by-hop truncated", true, 10, 100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := ipv6Packet(t, tc.hbh, make([]byte, tc.captured), tc.payloadLen)
+			tcp := p.TransportLayer().(*layers.TCP)
+
+			if n := tcpPayloadLen(p.NetworkLayer(), tcp); n != tc.payloadLen {
+				t.Fatalf("got payload length %d, want %d", n, tc.payloadLen)
+			}
+		})
+	}
+}
+
+func TestTCPPayloadLenJumbogram(t *testing.T) {
+	const payloadLen = 100000
+
+	jumbo := &layers.IPv6HopByHopOption{}
+	jumbo.SetJumboLength(8 + 20 + payloadLen)
+
+	hbh := &layers.IPv6HopByHop{Options: []*layers.IPv6HopByHopOption{jumbo}}
+	hbh.Contents = make([]byte, 8)
+
+	tcp := &layers.TCP{DataOffset: 5}
+	tcp.Contents = make([]byte, 20)
+	tcp.Payload = make([]byte, 10)
+
+	// gopacket leaves hop-by-hop header of a jumbogram in the payload.
+	ip := &layers.IPv6{Length: 0, HopByHop: hbh}
+	ip.Payload = make([]byte, len(hbh.Contents)+len(tcp.Contents)+len(tcp.Payload))
+
+	if n := tcpPayloadLen(ip, tcp); n != payloadLen {
+		t.Fatalf("got payload length %d, want %d", n, payloadLen)
+	}
+}
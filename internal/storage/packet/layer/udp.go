@@ -20,7 +20,7 @@ CREATE TABLE udp(
     checksum INT NOT NULL
 )`
 
-type UDPStorage struct{ db *sqlx.DB }
+type UDPStorage struct{}
 
 var _ storage.LayerStorage = (*UDPStorage)(nil)
 
@@ -29,15 +29,14 @@ func (s *UDPStorage) Init(db *sqlx.DB) error {
 	if err != nil {
 		return errors.Wrap(err, "udp storage: creating udp table")
 	}
-	s.db = db
 	return nil
 }
 
-func (s *UDPStorage) Store(ctx context.Context, packet container.Packet) error {
+func (s *UDPStorage) Store(ctx context.Context, b *storage.Batch, packet container.Packet) error {
 	udp := packet.TransportLayer().(*layers.UDP)
 	schema := udpToSchema(packet.ID, udp)
 
-	_, err := s.db.NamedExecContext(ctx,
+	_, err := b.NamedExecContext(ctx,
 		`INSERT INTO udp VALUES(
 			:id, :src, :dst, :length, :checksum
 		)`, schema)
@@ -0,0 +1,102 @@
+package storage_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/onee-only/netrat/internal/container"
+	"github.com/onee-only/netrat/internal/storage"
+	pstoragefactory "github.com/onee-only/netrat/internal/storage/packet/factory"
+)
+
+func benchPacket(b *testing.B) gopacket.Packet {
+	b.Helper()
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IPv4(10, 0, 0, 1),
+		DstIP:    net.IPv4(10, 0, 0, 2),
+	}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 80, ACK: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload("hello")); err != nil {
+		b.Fatal(err)
+	}
+
+	p := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	p.Metadata().Timestamp = time.Now()
+	p.Metadata().CaptureLength = len(buf.Bytes())
+	p.Metadata().Length = len(buf.Bytes())
+	return p
+}
+
+// BenchmarkPacketStorage compares storing ethernet, ipv4 and tcp packets
+// as before batching, where the database was in the default journal mode
+// and the packet row and each layer row were committed on their own,
+// with the batched writer on the database in WAL mode.
+func BenchmarkPacketStorage(b *testing.B) {
+	for _, bc := range []struct {
+		name       string
+		batched    bool
+		opts       storage.PacketStorageOptions
+		newCapture func(path string) (*storage.CaptureStorage, error)
+	}{
+		{"unbatched", false, storage.PacketStorageOptions{}, storage.NewUnbatchedCaptureStorage},
+		{"batched", true, storage.PacketStorageOptions{BatchSize: 1000, FlushInterval: 500 * time.Millisecond}, storage.NewCaptureStorage},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			capStorage, err := bc.newCapture(b.TempDir())
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			s, err := storage.NewPacketStorage(capStorage, bc.opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			store := s.StoreUnbatched
+			if bc.batched {
+				store = s.Store
+			}
+
+			for _, t := range []gopacket.LayerType{layers.LayerTypeEthernet, layers.LayerTypeIPv4, layers.LayerTypeTCP} {
+				if err := s.Register(t, pstoragefactory.New(t)); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			packet := benchPacket(b)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p := container.Packet{Packet: packet, ID: uuid.New()}
+				if err := store(ctx, p); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			// queued packets are committed on close.
+			if err := s.Close(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/jmoiron/sqlx"
+	"github.com/onee-only/netrat/internal/container"
+	"github.com/pkg/errors"
+)
+
+const pcapngTables = `
+CREATE TABLE pcapng_interface(
+	idx INTEGER NOT NULL PRIMARY KEY,
+	name TEXT NOT NULL,
+	link_type INTEGER NOT NULL,
+	snaplen INTEGER NOT NULL
+);
+CREATE TABLE pcapng_file(
+	id INTEGER NOT NULL PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+CREATE TABLE pcapng_packet(
+	id BLOB NOT NULL PRIMARY KEY,
+	file_id INTEGER NOT NULL,
+	offset INTEGER NOT NULL,
+	FOREIGN KEY(id) REFERENCES packet(id),
+	FOREIGN KEY(file_id) REFERENCES pcapng_file(id)
+)`
+
+// PcapngOptions configures the raw packet dump.
+type PcapngOptions struct {
+	// Enabled makes the worker write raw packets to pcapng files.
+	Enabled bool
+
+	// RotateSize and RotateInterval start a new file when the current
+	// file reaches the size in bytes or gets older than the interval.
+	// Zero disables the rotation.
+	RotateSize     uint64
+	RotateInterval time.Duration
+
+	// MaxFiles is the number of files kept. Oldest file is removed
+	// along with its index entries when exceeded. Zero keeps all files.
+	MaxFiles int
+}
+
+// PcapngInterface describes the source of dumped packets.
+type PcapngInterface struct {
+	Name     string
+	LinkType layers.LinkType
+	SnapLen  uint32
+}
+
+// PcapngStorage writes raw packets to rotating pcapng files
+// and indexes the file and offset of each packet.
+// It is registered to PacketStorage with RegisterRaw, so that the index
+// is committed with the packet and rotation is ordered with the inserts.
+type PcapngStorage struct {
+	opts PcapngOptions
+
+	db   *sqlx.DB
+	base string
+
+	ifaces    []PcapngInterface
+	ifaceIdxs map[string]int
+
+	// files holds the ids of files not removed yet, oldest first.
+	files []int64
+	seq   int
+
+	file    *os.File
+	writer  *pcapgo.NgWriter
+	offset  uint64
+	created time.Time
+}
+
+func NewPcapngStorage(capStorage *CaptureStorage, opts PcapngOptions) (*PcapngStorage, error) {
+	storage := &PcapngStorage{
+		opts: opts,
+		db:   capStorage.db,
+		base: filepath.Join(capStorage.path, "pcapng"),
+	}
+
+	if err := os.Mkdir(storage.base, 0755); err != nil {
+		return nil, errors.Wrap(err, "pcapng storage: creating base dir")
+	}
+
+	if _, err := storage.db.Exec(pcapngTables); err != nil {
+		return nil, errors.Wrap(err, "pcapng storage: creating tables")
+	}
+
+	return storage, nil
+}
+
+// Open sets the interfaces packets are captured from.
+// It must be called before Store.
+func (s *PcapngStorage) Open(ctx context.Context, ifaces []PcapngInterface) error {
+	s.ifaces = ifaces
+	s.ifaceIdxs = make(map[string]int, len(ifaces))
+	for idx, iface := range ifaces {
+		s.ifaceIdxs[iface.Name] = idx
+
+		_, err := s.db.ExecContext(ctx, "INSERT INTO pcapng_interface VALUES(?, ?, ?, ?)",
+			idx, iface.Name, iface.LinkType, iface.SnapLen)
+		if err != nil {
+			return errors.Wrap(err, "pcapng storage: inserting interface")
+		}
+	}
+	return nil
+}
+
+// Store writes packet to the current file and inserts its index in b.
+func (s *PcapngStorage) Store(ctx context.Context, b *Batch, packet container.Packet) error {
+	if s.writer == nil || s.shouldRotate() {
+		if err := s.rotate(ctx, b); err != nil {
+			return err
+		}
+	}
+
+	idx, ok := s.ifaceIdxs[packet.Interface]
+	if !ok {
+		return fmt.Errorf("pcapng storage: unknown interface %q", packet.Interface)
+	}
+
+	ci := packet.Metadata().CaptureInfo
+	ci.InterfaceIndex = idx
+
+	if err := s.writer.WritePacket(ci, packet.Data()); err != nil {
+		return errors.Wrap(err, "pcapng storage: writing packet")
+	}
+
+	offset := s.offset
+	s.offset += blockLength(len(packet.Data()))
+
+	schema := struct {
+		ID     []byte `db:"id"`
+		FileID int64  `db:"file_id"`
+		Offset uint64 `db:"offset"`
+	}{
+		ID:     packet.ID[:],
+		FileID: s.files[len(s.files)-1],
+		Offset: offset,
+	}
+
+	_, err := b.NamedExecContext(ctx, "INSERT INTO pcapng_packet VALUES(:id, :file_id, :offset)", schema)
+	if err != nil {
+		return errors.Wrap(err, "pcapng storage: inserting index")
+	}
+
+	return nil
+}
+
+func (s *PcapngStorage) shouldRotate() bool {
+	if s.opts.RotateSize > 0 && s.offset >= s.opts.RotateSize {
+		return true
+	}
+	if s.opts.RotateInterval > 0 && time.Since(s.created) >= s.opts.RotateInterval {
+		return true
+	}
+	return false
+}
+
+// rotate closes the current file and starts a new one.
+func (s *PcapngStorage) rotate(ctx context.Context, b *Batch) error {
+	if err := s.closeFile(); err != nil {
+		return err
+	}
+
+	created := time.Now()
+	name := fmt.Sprintf("capture-%05d.pcapng", s.seq+1)
+
+	file, err := os.Create(filepath.Join(s.base, name))
+	if err != nil {
+		return errors.Wrap(err, "pcapng storage: creating file")
+	}
+
+	counter := &countingWriter{w: file}
+	writer, err := s.newWriter(counter)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	res, err := b.tx.ExecContext(ctx, "INSERT INTO pcapng_file(name, created_at) VALUES(?, ?)", name, created)
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "pcapng storage: inserting file")
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "pcapng storage: getting file id")
+	}
+
+	// the file row is gone if the batch is rolled back,
+	// so the file is abandoned and the next Store starts a new one.
+	files := s.files
+	b.afterRollback(func() {
+		s.files = files
+		if s.file == file {
+			s.closeFile()
+		}
+		os.Remove(filepath.Join(s.base, name))
+	})
+
+	s.file, s.writer = file, writer
+	s.offset, s.created = counter.n, created
+	s.files = append(s.files, id)
+	s.seq++
+
+	if s.opts.MaxFiles > 0 && len(s.files) > s.opts.MaxFiles {
+		if err := s.removeOldest(ctx, b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newWriter writes the headers to w and flushes them,
+// so that the following packet offsets can be computed.
+func (s *PcapngStorage) newWriter(w io.Writer) (*pcapgo.NgWriter, error) {
+	if len(s.ifaces) == 0 {
+		return nil, errors.New("pcapng storage: no interface opened")
+	}
+
+	writer, err := pcapgo.NewNgWriterInterface(w, ngInterface(s.ifaces[0]), pcapgo.DefaultNgWriterOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "pcapng storage: writing header")
+	}
+
+	for _, iface := range s.ifaces[1:] {
+		if _, err := writer.AddInterface(ngInterface(iface)); err != nil {
+			return nil, errors.Wrap(err, "pcapng storage: adding interface")
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return nil, errors.Wrap(err, "pcapng storage: flushing header")
+	}
+
+	return writer, nil
+}
+
+func (s *PcapngStorage) removeOldest(ctx context.Context, b *Batch) error {
+	id := s.files[0]
+
+	var name string
+	if err := b.tx.GetContext(ctx, &name, "SELECT name FROM pcapng_file WHERE id = ?", id); err != nil {
+		return errors.Wrap(err, "pcapng storage: selecting oldest file")
+	}
+
+	if _, err := b.tx.ExecContext(ctx, "DELETE FROM pcapng_packet WHERE file_id = ?", id); err != nil {
+		return errors.Wrap(err, "pcapng storage: deleting index")
+	}
+	if _, err := b.tx.ExecContext(ctx, "DELETE FROM pcapng_file WHERE id = ?", id); err != nil {
+		return errors.Wrap(err, "pcapng storage: deleting file")
+	}
+
+	// the file is still indexed until the batch is committed.
+	b.afterCommit(func() {
+		if err := os.Remove(filepath.Join(s.base, name)); err != nil && !os.IsNotExist(err) {
+			slog.Warn("pcapng storage: removing file", "name", name, "err", err)
+		}
+	})
+
+	s.files = s.files[1:]
+	return nil
+}
+
+func (s *PcapngStorage) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+
+	file, writer := s.file, s.writer
+	s.file, s.writer = nil, nil
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return errors.Wrap(err, "pcapng storage: flushing file")
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "pcapng storage: closing file")
+	}
+	return nil
+}
+
+// Close flushes and closes the current file.
+// It must be called after PacketStorage is closed.
+func (s *PcapngStorage) Close() error {
+	return s.closeFile()
+}
+
+// blockLength returns the length of enhanced packet block
+// written by pcapgo.NgWriter for data of length n.
+func blockLength(n int) uint64 {
+	length := uint64(n) + 32
+	return length + (4-length&3)&3
+}
+
+func ngInterface(iface PcapngInterface) pcapgo.NgInterface {
+	return pcapgo.NgInterface{
+		Name:       iface.Name,
+		LinkType:   iface.LinkType,
+		SnapLength: iface.SnapLen,
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += uint64(n)
+	return n, err
+}
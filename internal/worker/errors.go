@@ -0,0 +1,14 @@
+package worker
+
+import "fmt"
+
+// OptionError reports an invalid worker option.
+type OptionError struct {
+	// Option is the name of the invalid field.
+	Option string
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("invalid option %s: %s", e.Option, e.Reason)
+}
@@ -2,118 +2,561 @@ package worker
 
 import (
 	"context"
+	"fmt"
+	"maps"
+	"os"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
-	"github.com/google/uuid"
 	"github.com/onee-only/netrat/internal/config"
 	"github.com/onee-only/netrat/internal/container"
+	"github.com/onee-only/netrat/internal/device"
+	pstoragefactory "github.com/onee-only/netrat/internal/storage/packet/factory"
+	"github.com/onee-only/netrat/pkg/stat"
 	"github.com/pkg/errors"
 )
 
 type ListenOptions struct {
-	Device, PcapFile string
+	// Devices are captured at once and merged by timestamp.
+	// PcapFile is read when no device is given.
+	Devices  []string
+	PcapFile string
 
 	SnapLen     int32
 	Promiscuous bool
 	BPFFilter   string
 
-	Timeout       time.Duration
 	CaptureLayers []gopacket.LayerType
+
+	// Timeout, MaxPackets and MaxBytes stop the listener
+	// when reached. Zero means no limit.
+	// MaxBytes is compared with the sum of wire length,
+	// and packet that would exceed it is not passed on.
+	// Packets dropped by DropPolicy count towards the limits.
+	Timeout    time.Duration
+	MaxPackets uint64
+	MaxBytes   uint64
+
+	// Backend captures packets from Devices. Empty means BackendPcap.
+	Backend  Backend
+	AFPacket AFPacketOptions
+
+	// ReplaySpeed paces packets of PcapFile by their capture
+	// timestamps, multiplied by the speed. Zero reads as fast as possible.
+	ReplaySpeed float64
+
+	// BufferSize is the number of captured packets waiting to be stored.
+	// Zero uses the size configured for netratd.
+	BufferSize int
+	// DropPolicy decides what happens to packets captured while
+	// the buffer is full. Empty means DropPolicyBlock.
+	DropPolicy DropPolicy
+}
+
+// DropPolicy decides what the listener does when the packet buffer
+// is full. Blocking leaves dropping to the kernel, and the others drop
+// in userspace so that capturing keeps up with the devices.
+type DropPolicy string
+
+const (
+	// DropPolicyBlock waits until the buffer has room.
+	DropPolicyBlock DropPolicy = "block"
+	// DropPolicyNewest drops the packet just captured.
+	DropPolicyNewest DropPolicy = "drop-newest"
+	// DropPolicyOldest drops the oldest packet in the buffer.
+	DropPolicyOldest DropPolicy = "drop-oldest"
+)
+
+func (p DropPolicy) Valid() bool {
+	switch p {
+	case DropPolicyBlock, DropPolicyNewest, DropPolicyOldest:
+		return true
+	}
+	return false
 }
 
+// Validate checks the options and fills in defaults.
+// The source is opened briefly to check that it is readable
+// and that BPFFilter compiles for its link type.
 func (o *ListenOptions) Validate() (*ListenOptions, error) {
 	if o == nil {
 		o = &ListenOptions{}
 	}
 
-	if o.Device == "" && o.PcapFile == "" {
-		return nil, errors.New("listener: device name and pcap file not specified")
+	if len(o.Devices) == 0 && o.PcapFile == "" {
+		return nil, &OptionError{Option: "Devices", Reason: "device name and pcap file not specified"}
+	}
+
+	slices.Sort(o.Devices)
+	o.Devices = slices.Compact(o.Devices)
+
+	if err := o.validateBackend(); err != nil {
+		return nil, err
+	}
+
+	if o.ReplaySpeed < 0 {
+		return nil, &OptionError{Option: "ReplaySpeed", Reason: "must not be negative"}
+	}
+
+	if o.ReplaySpeed > 0 && len(o.Devices) > 0 {
+		return nil, &OptionError{Option: "ReplaySpeed", Reason: "replay applies to pcap file only"}
+	}
+
+	if o.BufferSize < 0 {
+		return nil, &OptionError{Option: "BufferSize", Reason: "must not be negative"}
+	}
+
+	if o.DropPolicy == "" {
+		o.DropPolicy = DropPolicyBlock
+	}
+
+	if !o.DropPolicy.Valid() {
+		return nil, &OptionError{Option: "DropPolicy", Reason: fmt.Sprintf("unknown drop policy %q", o.DropPolicy)}
+	}
+
+	if o.DropPolicy != DropPolicyBlock && len(o.Devices) == 0 {
+		return nil, &OptionError{Option: "DropPolicy", Reason: "dropping applies to devices only"}
 	}
 
 	if len(o.CaptureLayers) == 0 {
-		return nil, errors.New("listener: capture layer not specified")
+		return nil, &OptionError{Option: "CaptureLayers", Reason: "capture layer not specified"}
 	}
 
 	slices.Sort(o.CaptureLayers)
 	o.CaptureLayers = slices.Compact(o.CaptureLayers)
 
+	unsupported := make([]gopacket.LayerType, 0)
+	for _, t := range o.CaptureLayers {
+		if pstoragefactory.New(t) == nil {
+			unsupported = append(unsupported, t)
+		}
+	}
+
+	if len(unsupported) > 0 {
+		return nil, &OptionError{
+			Option: "CaptureLayers",
+			Reason: fmt.Sprintf("unsupported capture layer(s): %s", unsupported),
+		}
+	}
+
 	if o.SnapLen <= 0 {
 		o.SnapLen = config.PacketSnapLen
 	}
 
+	linkTypes, err := o.probe()
+	if err != nil {
+		return nil, err
+	}
+
+	if o.BPFFilter != "" {
+		for _, linkType := range linkTypes {
+			if _, err := pcap.CompileBPFFilter(linkType, int(o.SnapLen), o.BPFFilter); err != nil {
+				return nil, &OptionError{Option: "BPFFilter", Reason: err.Error()}
+			}
+		}
+	}
+
 	return o, nil
 }
 
-type listener struct {
-	opts *ListenOptions
-}
+func (o *ListenOptions) validateBackend() error {
+	if o.Backend == "" {
+		o.Backend = BackendPcap
+	}
 
-func newListener(opts *ListenOptions) (l *listener, err error) {
-	opts, err = opts.Validate()
-	if err != nil {
-		return nil, err
+	if !o.Backend.Valid() {
+		return &OptionError{Option: "Backend", Reason: fmt.Sprintf("unknown backend %q", o.Backend)}
 	}
 
-	l = &listener{opts: opts}
+	if o.Backend != BackendAFPacket {
+		return nil
+	}
 
-	return
-}
+	if len(o.Devices) == 0 {
+		return &OptionError{Option: "Backend", Reason: "afpacket cannot read pcap file"}
+	}
 
-func (l *listener) listen(ctx context.Context) (_ <-chan container.Packet, err error) {
-	packetStream := make(chan container.Packet, config.PacketStreamBufSize)
+	if !slices.Contains(fanoutTypes, o.AFPacket.FanoutType) && o.AFPacket.FanoutType != "" {
+		return &OptionError{
+			Option: "AFPacket.FanoutType",
+			Reason: fmt.Sprintf("unknown fanout type %q", o.AFPacket.FanoutType),
+		}
+	}
+
+	if o.AFPacket.Sockets > 1 && o.AFPacket.FanoutGroup == 0 {
+		return &OptionError{Option: "AFPacket.Sockets", Reason: "multiple sockets require fanout group"}
+	}
+
+	return nil
+}
 
-	var handle *pcap.Handle
-	if l.opts.Device != "" {
-		handle, err = pcap.OpenLive(l.opts.Device, l.opts.SnapLen, l.opts.Promiscuous, pcap.BlockForever)
+// probe checks the sources can be opened and returns their link types.
+func (o *ListenOptions) probe() ([]layers.LinkType, error) {
+	if len(o.Devices) == 0 {
+		linkType, err := o.probeFile()
 		if err != nil {
-			return nil, errors.Wrap(err, "listener: creating handle from device")
+			return nil, err
 		}
-	} else {
-		handle, err = pcap.OpenOffline(l.opts.PcapFile)
+		return []layers.LinkType{linkType}, nil
+	}
+
+	linkTypes := make([]layers.LinkType, 0, len(o.Devices))
+	for _, name := range o.Devices {
+		linkType, err := o.probeDevice(name)
 		if err != nil {
-			return nil, errors.Wrap(err, "listener: creating handle from pcap file")
+			return nil, err
 		}
+		linkTypes = append(linkTypes, linkType)
 	}
 
-	if l.opts.BPFFilter != "" {
-		if err := handle.SetBPFFilter(l.opts.BPFFilter); err != nil {
-			return nil, errors.Wrap(err, "listener: setting BPF filter")
+	slices.Sort(linkTypes)
+	return slices.Compact(linkTypes), nil
+}
+
+func (o *ListenOptions) probeDevice(name string) (layers.LinkType, error) {
+	if _, err := device.Lookup(name); err != nil {
+		if errors.Is(err, device.ErrNotFound) {
+			return 0, &OptionError{Option: "Devices", Reason: fmt.Sprintf("device %q not found", name)}
+		}
+		return 0, errors.Wrap(err, "listener: looking up device")
+	}
+
+	// open with the backend the worker captures with, since
+	// the link type and the permissions needed differ by backend.
+	src, err := openSource(name, o)
+	if err != nil {
+		return 0, &OptionError{Option: "Devices", Reason: err.Error()}
+	}
+	defer src.Close()
+
+	return src.LinkType(), nil
+}
+
+func (o *ListenOptions) probeFile() (layers.LinkType, error) {
+	if _, err := os.Stat(o.PcapFile); err != nil {
+		return 0, &OptionError{Option: "PcapFile", Reason: err.Error()}
+	}
+
+	handle, err := pcap.OpenOffline(o.PcapFile)
+	if err != nil {
+		return 0, &OptionError{Option: "PcapFile", Reason: err.Error()}
+	}
+	defer handle.Close()
+
+	return handle.LinkType(), nil
+}
+
+type listener struct {
+	opts    *ListenOptions
+	bufSize int
+
+	handles   []sourceHandle
+	linkTypes map[string]layers.LinkType
+	// progress is nil unless reading pcap file.
+	progress *fileProgress
+	// lastStats is indexed the same as handles.
+	lastStats []sourceStats
+	// dropped counts packets dropped by DropPolicy by device name.
+	dropped map[string]uint64
+
+	closed bool
+	reason stat.StopReason
+	lock   sync.Mutex
+}
+
+// sourceHandle is an open source of the device name.
+// Source of pcap file has empty name.
+type sourceHandle struct {
+	name   string
+	source captureSource
+}
+
+// newListener creates listener with validated opts.
+// bufSize is used unless opts.BufferSize is set.
+func newListener(opts *ListenOptions, bufSize int) *listener {
+	if opts.BufferSize > 0 {
+		bufSize = opts.BufferSize
+	}
+	// unbuffered stream is never full, so nothing could be dropped.
+	if opts.DropPolicy != DropPolicyBlock && bufSize < 1 {
+		bufSize = 1
+	}
+
+	return &listener{opts: opts, bufSize: bufSize, dropped: make(map[string]uint64)}
+}
+
+func (l *listener) listen(ctx context.Context) (_ <-chan container.Packet, err error) {
+	packetStream := make(chan container.Packet, l.bufSize)
+
+	handles, err := l.open()
+	if err != nil {
+		return nil, err
+	}
+
+	var progress *fileProgress
+	if len(l.opts.Devices) == 0 {
+		if progress, err = newFileProgress(l.opts.PcapFile); err != nil {
+			handles[0].source.Close()
+			return nil, err
 		}
 	}
 
-	var timeout <-chan time.Time
-	if l.opts.Timeout > 0 {
-		timeout = time.NewTimer(l.opts.Timeout).C
+	linkTypes := make(map[string]layers.LinkType, len(handles))
+	for _, h := range handles {
+		linkTypes[h.name] = h.source.LinkType()
+	}
+
+	l.lock.Lock()
+	l.handles = handles
+	l.linkTypes = linkTypes
+	l.lastStats = make([]sourceStats, len(handles))
+	l.progress = progress
+	l.lock.Unlock()
+
+	sources := make([]source, 0, len(handles))
+	for _, h := range handles {
+		sources = append(sources, source{
+			iface:   h.name,
+			packets: gopacket.NewPacketSource(h.source, h.source.LinkType()).Packets(),
+		})
 	}
 
-	packets := gopacket.NewPacketSource(handle, handle.LinkType()).Packets()
+	if progress != nil {
+		sources[0].speed = l.opts.ReplaySpeed
+		sources[0].progress = progress
+	}
 
 	go func() {
 		defer close(packetStream)
-		defer handle.Close()
+		defer l.closeHandles()
 
-		var packet gopacket.Packet
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-timeout:
+		c, cancel := context.WithCancel(ctx)
+		if l.opts.Timeout > 0 {
+			c, cancel = context.WithTimeout(ctx, l.opts.Timeout)
+		}
+		defer cancel()
+
+		merged := make(chan container.Packet)
+		go func() {
+			defer close(merged)
+			if len(sources) == 1 {
+				sources[0].forward(c, merged)
 				return
-			case packet = <-packets:
-				if packet == nil {
-					return
-				}
 			}
+			merge(c, sources, merged)
+		}()
 
-			packetStream <- container.Packet{
-				ID:     uuid.New(),
-				Packet: packet,
+		reason := l.pass(ctx, merged, packetStream)
+		cancel()
+
+		// wait for sources to stop before handles are closed.
+		for range merged {
+		}
+
+		if reason == stat.StopReasonNone && ctx.Err() == nil {
+			reason = stat.StopReasonEndOfSource
+			if errors.Is(c.Err(), context.DeadlineExceeded) {
+				reason = stat.StopReasonTimeout
 			}
 		}
+
+		l.lock.Lock()
+		l.reason = reason
+		l.lock.Unlock()
 	}()
 
 	return packetStream, nil
 }
+
+// pass sends packets from in to out until in is closed or limit is reached.
+func (l *listener) pass(ctx context.Context, in <-chan container.Packet, out chan container.Packet) stat.StopReason {
+	var packets, bytes uint64
+
+	for packet := range in {
+		length := uint64(packet.Metadata().Length)
+		if l.opts.MaxBytes > 0 && bytes+length > l.opts.MaxBytes {
+			return stat.StopReasonMaxBytes
+		}
+
+		if !l.send(ctx, out, packet) {
+			return stat.StopReasonNone
+		}
+
+		packets++
+		bytes += length
+
+		if l.opts.MaxPackets > 0 && packets >= l.opts.MaxPackets {
+			return stat.StopReasonMaxPackets
+		}
+	}
+
+	return stat.StopReasonNone
+}
+
+// send passes packet to out following the drop policy.
+// It returns false if ctx is done while blocked.
+func (l *listener) send(ctx context.Context, out chan container.Packet, packet container.Packet) bool {
+	switch l.opts.DropPolicy {
+	case DropPolicyNewest:
+		select {
+		case out <- packet:
+		default:
+			l.drop(packet)
+		}
+		return true
+
+	case DropPolicyOldest:
+		for {
+			select {
+			case out <- packet:
+				return true
+			default:
+			}
+
+			// the consumer may take the oldest first,
+			// then there is room for the packet.
+			select {
+			case old := <-out:
+				l.drop(old)
+			default:
+			}
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case out <- packet:
+		return true
+	}
+}
+
+func (l *listener) drop(packet container.Packet) {
+	l.lock.Lock()
+	l.dropped[packet.Interface]++
+	l.lock.Unlock()
+}
+
+// fileProgress returns the progress of reading pcap file,
+// or nil for live sources.
+func (l *listener) fileProgress() *fileProgress {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.progress
+}
+
+// stopReason returns why the listener stopped by itself.
+// It is StopReasonNone while listening or if it was stopped by ctx.
+func (l *listener) stopReason() stat.StopReason {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.reason
+}
+
+// open opens handles for all sources.
+func (l *listener) open() (handles []sourceHandle, err error) {
+	defer func() {
+		if err != nil {
+			for _, h := range handles {
+				h.source.Close()
+			}
+		}
+	}()
+
+	if len(l.opts.Devices) == 0 {
+		src, err := openFileSource(l.opts.PcapFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "listener: creating handle from pcap file")
+		}
+		handles = append(handles, sourceHandle{source: src})
+	}
+
+	sockets := 1
+	if l.opts.Backend == BackendAFPacket && l.opts.AFPacket.Sockets > 1 {
+		sockets = l.opts.AFPacket.Sockets
+	}
+
+	for _, name := range l.opts.Devices {
+		for range sockets {
+			src, err := openSource(name, l.opts)
+			if err != nil {
+				return handles, errors.Wrapf(err, "listener: creating handle from device %s", name)
+			}
+			handles = append(handles, sourceHandle{name: name, source: src})
+		}
+	}
+
+	if l.opts.BPFFilter != "" {
+		for _, h := range handles {
+			if err := h.source.SetBPFFilter(l.opts.BPFFilter); err != nil {
+				return handles, errors.Wrap(err, "listener: setting BPF filter")
+			}
+		}
+	}
+
+	return handles, nil
+}
+
+// sourceLinkTypes returns the link type of each source keyed
+// the same as the handles. It is empty until listen is called.
+func (l *listener) sourceLinkTypes() map[string]layers.LinkType {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return maps.Clone(l.linkTypes)
+}
+
+// stats returns the statistics of the live sources by device name.
+// After the sources are closed, the last statistics taken are returned.
+func (l *listener) stats() map[string]sourceStats {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.updateStats()
+
+	stats := make(map[string]sourceStats)
+	for idx, h := range l.handles {
+		if h.name == "" {
+			continue
+		}
+		s := stats[h.name]
+		s.KernelDropped += l.lastStats[idx].KernelDropped
+		s.IfDropped += l.lastStats[idx].IfDropped
+		stats[h.name] = s
+	}
+	for name, n := range l.dropped {
+		s := stats[name]
+		s.UserDropped = n
+		stats[name] = s
+	}
+	return stats
+}
+
+func (l *listener) closeHandles() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.updateStats()
+	for _, h := range l.handles {
+		h.source.Close()
+	}
+	l.closed = true
+}
+
+// updateStats must be called with lock held.
+func (l *listener) updateStats() {
+	if l.closed {
+		return
+	}
+	for idx, h := range l.handles {
+		if s, ok := h.source.Stats(); ok {
+			l.lastStats[idx] = s
+		}
+	}
+}
@@ -1,37 +1,216 @@
 package manager
 
 import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
 	"github.com/google/uuid"
+	"github.com/onee-only/netrat/internal/export"
 	"github.com/onee-only/netrat/internal/worker"
 	"github.com/onee-only/netrat/pkg/stat"
 	"github.com/pkg/errors"
 )
 
+var ErrWorkerNotFound = errors.New("worker not found")
+
+// Manager keeps track of workers.
+// It is safe for concurrent use.
 type Manager struct {
 	workers map[uuid.UUID]*worker.Worker
+	lock    sync.RWMutex
 }
 
 func New() *Manager {
 	return &Manager{workers: make(map[uuid.UUID]*worker.Worker)}
 }
 
+// Restore registers workers persisted under dataPath.
+// Namespaces that cannot be restored are skipped.
+func (m *Manager) Restore(dataPath string) error {
+	entries, err := os.ReadDir(dataPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return errors.Wrap(err, "reading data path")
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := uuid.Parse(entry.Name()); err != nil {
+			continue
+		}
+
+		path := filepath.Join(dataPath, entry.Name())
+		w, err := worker.Restore(path)
+		if err != nil {
+			slog.Warn("skipping worker namespace", "path", path, "err", err)
+			continue
+		}
+
+		m.RegisterWorker(w)
+	}
+
+	return nil
+}
+
 func (m *Manager) RegisterWorker(w *worker.Worker) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	m.workers[w.ID()] = w
 }
 
+func (m *Manager) CancelWorker(id uuid.UUID) error {
+	w, err := m.get(id)
+	if err != nil {
+		return err
+	}
+
+	if !w.Cancel() {
+		return errors.New("worker is not running")
+	}
+
+	return nil
+}
+
+func (m *Manager) PauseWorker(id uuid.UUID) error {
+	w, err := m.get(id)
+	if err != nil {
+		return err
+	}
+
+	if !w.Pause() {
+		return errors.New("worker is not running")
+	}
+
+	return nil
+}
+
+func (m *Manager) ResumeWorker(id uuid.UUID) error {
+	w, err := m.get(id)
+	if err != nil {
+		return err
+	}
+
+	if !w.Resume() {
+		return errors.New("worker is not paused")
+	}
+
+	return nil
+}
+
+// DeleteWorker stops the worker and removes it from the manager.
+// Captured data is removed as well unless keepData is set.
+func (m *Manager) DeleteWorker(ctx context.Context, id uuid.UUID, keepData bool) error {
+	w, err := m.get(id)
+	if err != nil {
+		return err
+	}
+
+	w.Cancel()
+
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(context.Cause(ctx), "waiting worker to be done")
+	case <-w.Done():
+	}
+
+	m.lock.Lock()
+	if m.workers[id] != w {
+		// deleted by another request while waiting.
+		m.lock.Unlock()
+		return ErrWorkerNotFound
+	}
+	delete(m.workers, id)
+	m.lock.Unlock()
+
+	if !keepData {
+		if err := os.RemoveAll(w.Path()); err != nil {
+			return errors.Wrap(err, "removing worker namespace")
+		}
+	}
+
+	return nil
+}
+
+// Shutdown cancels all workers and waits for them to be done.
+// It returns when every worker is done or ctx is done, in which case
+// the records of workers still running are saved as they are.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.lock.RLock()
+	workers := make([]*worker.Worker, 0, len(m.workers))
+	for _, w := range m.workers {
+		workers = append(workers, w)
+	}
+	m.lock.RUnlock()
+
+	for _, w := range workers {
+		w.Cancel()
+	}
+
+	for i, w := range workers {
+		select {
+		case <-ctx.Done():
+			for _, w := range workers[i:] {
+				w.Save()
+			}
+			return errors.Wrap(context.Cause(ctx), "waiting workers to be done")
+		case <-w.Done():
+		}
+	}
+
+	return nil
+}
+
 func (m *Manager) All() (stats []stat.Worker) {
-	stats = make([]stat.Worker, 0, len(m.workers))
+	m.lock.RLock()
+	workers := make([]*worker.Worker, 0, len(m.workers))
 	for _, w := range m.workers {
+		workers = append(workers, w)
+	}
+	m.lock.RUnlock()
+
+	stats = make([]stat.Worker, 0, len(workers))
+	for _, w := range workers {
 		stats = append(stats, w.ExportStats())
 	}
 	return
 }
 
 func (m *Manager) FetchStat(id uuid.UUID) (stat.Worker, error) {
-	w, ok := m.workers[id]
-	if !ok {
-		return stat.Worker{}, errors.New("worker not found")
+	w, err := m.get(id)
+	if err != nil {
+		return stat.Worker{}, err
 	}
 
 	return w.ExportStats(), nil
 }
+
+// Export writes packets of the worker with given id to w.
+func (m *Manager) Export(ctx context.Context, id uuid.UUID, opts export.Options, w io.Writer) (uint64, error) {
+	worker, err := m.get(id)
+	if err != nil {
+		return 0, err
+	}
+
+	return export.Export(ctx, worker.Path(), opts, w)
+}
+
+func (m *Manager) get(id uuid.UUID) (*worker.Worker, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	w, ok := m.workers[id]
+	if !ok {
+		return nil, ErrWorkerNotFound
+	}
+	return w, nil
+}
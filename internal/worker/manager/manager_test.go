@@ -0,0 +1,222 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/onee-only/netrat/internal/worker"
+)
+
+// writePcap writes n tcp packets 10ms apart to a pcap file in dir.
+func writePcap(t *testing.T, dir string, n int) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "in.pcap")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolTCP,
+			SrcIP:    net.IPv4(10, 0, 0, 1),
+			DstIP:    net.IPv4(10, 0, 0, 2),
+		}
+		tcp := &layers.TCP{SrcPort: 40000, DstPort: 80, Seq: uint32(i), ACK: true, Window: 1024}
+		tcp.SetNetworkLayerForChecksum(ip)
+
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload("hello")); err != nil {
+			t.Fatal(err)
+		}
+
+		data := buf.Bytes()
+		ci := gopacket.CaptureInfo{
+			Timestamp:     start.Add(time.Duration(i) * 10 * time.Millisecond),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path
+}
+
+func newWorker(cfg worker.Config, pcapFile string) (*worker.Worker, error) {
+	opts := &worker.WorkerOptions{
+		ListenOptions: worker.ListenOptions{
+			PcapFile:      pcapFile,
+			CaptureLayers: []gopacket.LayerType{layers.LayerTypeIPv4, layers.LayerTypeTCP},
+			// replay in real time so that workers are still running
+			// while the manager is used.
+			ReplaySpeed: 1,
+		},
+	}
+
+	w, ctx, err := worker.New(context.Background(), cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	go w.Exec(ctx)
+	return w, nil
+}
+
+func TestManagerConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	pcapFile := writePcap(t, dir, 50)
+
+	cfg := worker.Config{
+		DataPath:            filepath.Join(dir, "data"),
+		SnapLen:             65535,
+		PacketStreamBufSize: 16,
+		PacketBatchSize:     8,
+		PacketFlushInterval: 10 * time.Millisecond,
+		AssembleTimeout:     time.Second,
+	}
+
+	const n = 8
+
+	m := New()
+	ids := make(chan uuid.UUID, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w, err := newWorker(cfg, pcapFile)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			m.RegisterWorker(w)
+			ids <- w.ID()
+		}()
+	}
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				for _, s := range m.All() {
+					if _, err := m.FetchStat(s.ID); err != nil && !errors.Is(err, ErrWorkerNotFound) {
+						t.Error(err)
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(ids)
+
+	if t.Failed() {
+		close(stop)
+		readers.Wait()
+		t.FailNow()
+	}
+
+	var (
+		deleted = make(map[uuid.UUID]bool)
+		wins    = make(map[uuid.UUID]*atomic.Int32)
+		ops     sync.WaitGroup
+	)
+	i := 0
+	for id := range ids {
+		id, del := id, i%2 == 0
+		deleted[id] = del
+		wins[id] = new(atomic.Int32)
+		i++
+
+		if !del {
+			ops.Add(1)
+			go func() {
+				defer ops.Done()
+				// worker may have finished already.
+				m.CancelWorker(id)
+			}()
+			continue
+		}
+
+		// deleting same worker twice at once must have a single winner.
+		for j := 0; j < 2; j++ {
+			ops.Add(1)
+			go func() {
+				defer ops.Done()
+				err := m.DeleteWorker(context.Background(), id, false)
+				switch {
+				case err == nil:
+					wins[id].Add(1)
+				case !errors.Is(err, ErrWorkerNotFound):
+					t.Errorf("deleting worker %s: %v", id, err)
+				}
+			}()
+		}
+	}
+	ops.Wait()
+
+	for id, del := range deleted {
+		if del && wins[id].Load() != 1 {
+			t.Errorf("worker %s deleted %d times", id, wins[id].Load())
+		}
+	}
+
+	close(stop)
+	readers.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := m.All()
+	if len(stats) != n/2 {
+		t.Fatalf("got %d workers after deleting half of %d", len(stats), n)
+	}
+	for _, s := range stats {
+		if deleted[s.ID] {
+			t.Errorf("deleted worker %s is still registered", s.ID)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package worker
+
+import (
+	"container/heap"
+	"context"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/uuid"
+	"github.com/onee-only/netrat/internal/container"
+)
+
+// mergeWindow is how long a packet is held back waiting for
+// packets of other interfaces with earlier timestamp.
+const mergeWindow = 100 * time.Millisecond
+
+// source is a packet stream captured from single interface.
+type source struct {
+	iface   string
+	packets <-chan gopacket.Packet
+
+	// speed paces the packets by their timestamps if not zero.
+	speed float64
+	// progress is updated as packets are read if not nil.
+	progress *fileProgress
+}
+
+func (s source) forward(ctx context.Context, out chan<- container.Packet) {
+	var first, start time.Time
+
+	for {
+		var packet gopacket.Packet
+		select {
+		case <-ctx.Done():
+			return
+		case packet = <-s.packets:
+			if packet == nil {
+				if s.progress != nil {
+					s.progress.finish()
+				}
+				return
+			}
+		}
+
+		if s.progress != nil {
+			s.progress.add(packet.Metadata().CaptureLength)
+		}
+
+		if s.speed > 0 {
+			ts := packet.Metadata().Timestamp
+			if first.IsZero() {
+				first, start = ts, time.Now()
+			}
+
+			due := start.Add(time.Duration(float64(ts.Sub(first)) / s.speed))
+			if wait := time.Until(due); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case out <- newPacket(s.iface, packet):
+		}
+	}
+}
+
+func newPacket(iface string, packet gopacket.Packet) container.Packet {
+	return container.Packet{
+		Packet:    packet,
+		ID:        uuid.New(),
+		Interface: iface,
+	}
+}
+
+// merge sends packets of sources to out in timestamp order.
+//
+// Packet is held back until every open source has a pending packet
+// or it has waited for mergeWindow, so that a quiet interface
+// does not stall the others.
+func merge(ctx context.Context, sources []source, out chan<- container.Packet) {
+	type event struct {
+		src    int
+		packet gopacket.Packet
+	}
+
+	events := make(chan event)
+	for idx, s := range sources {
+		go func() {
+			for {
+				var packet gopacket.Packet
+				select {
+				case <-ctx.Done():
+					return
+				case packet = <-s.packets:
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case events <- event{src: idx, packet: packet}:
+				}
+
+				// nil packet reports closed source.
+				if packet == nil {
+					return
+				}
+			}
+		}()
+	}
+
+	var (
+		queue   = &pendingQueue{}
+		pending = make([]int, len(sources))
+		closed  = make([]bool, len(sources))
+		open    = len(sources)
+	)
+
+	ready := func() bool {
+		if queue.Len() == 0 {
+			return false
+		}
+		if time.Since((*queue)[0].arrived) >= mergeWindow {
+			return true
+		}
+		for idx := range sources {
+			if !closed[idx] && pending[idx] == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		for ready() {
+			p := heap.Pop(queue).(pendingPacket)
+			pending[p.src]--
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- newPacket(sources[p.src].iface, p.packet):
+			}
+		}
+
+		if open == 0 {
+			return
+		}
+
+		var (
+			timer *time.Timer
+			wait  <-chan time.Time
+		)
+		if queue.Len() > 0 {
+			timer = time.NewTimer(mergeWindow - time.Since((*queue)[0].arrived))
+			wait = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wait:
+		case e := <-events:
+			if e.packet == nil {
+				closed[e.src] = true
+				open--
+				break
+			}
+
+			pending[e.src]++
+			heap.Push(queue, pendingPacket{
+				src:     e.src,
+				packet:  e.packet,
+				arrived: time.Now(),
+			})
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+type pendingPacket struct {
+	src     int
+	packet  gopacket.Packet
+	arrived time.Time
+}
+
+// pendingQueue is a min-heap of packets ordered by capture timestamp.
+type pendingQueue []pendingPacket
+
+func (q pendingQueue) Len() int { return len(q) }
+
+func (q pendingQueue) Less(i, j int) bool {
+	return q[i].packet.Metadata().Timestamp.Before(q[j].packet.Metadata().Timestamp)
+}
+
+func (q pendingQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *pendingQueue) Push(x any) { *q = append(*q, x.(pendingPacket)) }
+
+func (q *pendingQueue) Pop() any {
+	old := *q
+	p := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return p
+}
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// fileProgress estimates how much of a pcap file is read.
+//
+// libpcap does not expose its position in the file, so it is
+// estimated from the record overhead of the file format.
+type fileProgress struct {
+	size     uint64
+	overhead uint64
+	start    time.Time
+
+	read atomic.Uint64
+}
+
+func newFileProgress(path string) (*fileProgress, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "listener: opening pcap file")
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "listener: stat pcap file")
+	}
+
+	magic := make([]byte, len(pcapngMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return nil, errors.Wrap(err, "listener: reading pcap file header")
+	}
+
+	p := &fileProgress{size: uint64(info.Size()), start: time.Now()}
+
+	if bytes.Equal(magic, pcapngMagic) {
+		// enhanced packet block without options.
+		p.overhead = 32
+	} else {
+		// file header, and record header of each packet.
+		p.overhead = 16
+		p.read.Store(24)
+	}
+
+	return p, nil
+}
+
+func (p *fileProgress) add(capLen int) {
+	p.read.Add(p.overhead + uint64(capLen))
+}
+
+// finish marks the file as fully read.
+func (p *fileProgress) finish() {
+	p.read.Store(p.size)
+}
+
+// state returns the number of bytes read and the estimated time left.
+func (p *fileProgress) state() (read, size uint64, eta time.Duration) {
+	read = min(p.read.Load(), p.size)
+	if read == 0 || read == p.size {
+		return read, p.size, 0
+	}
+
+	elapsed := time.Since(p.start)
+	eta = time.Duration(float64(elapsed) * float64(p.size-read) / float64(read))
+	return read, p.size, eta
+}
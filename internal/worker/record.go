@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/uuid"
+	"github.com/onee-only/netrat/pkg/assemble"
+	"github.com/onee-only/netrat/pkg/stat"
+	"github.com/pkg/errors"
+)
+
+const recordFileName = "worker.json"
+
+// record is the persisted form of a worker.
+// It is kept in the namespace of the worker so that the worker
+// can be restored after the daemon restarts.
+type record struct {
+	ID      uuid.UUID     `json:"id"`
+	Options WorkerOptions `json:"options"`
+
+	State      stat.WorkerState `json:"state"`
+	CreatedAt  time.Time        `json:"created_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	LastError  string           `json:"last_error,omitempty"`
+	StopReason stat.StopReason  `json:"stop_reason,omitempty"`
+
+	Counters counters `json:"counters"`
+}
+
+type counters struct {
+	Received  uint64 `json:"received"`
+	Bytes     uint64 `json:"bytes"`
+	Discarded uint64 `json:"discarded"`
+
+	Stored    map[gopacket.LayerType]uint64    `json:"stored"`
+	Assembled map[assemble.AssembleType]uint64 `json:"assembled"`
+
+	KernelDropped uint64 `json:"kernel_dropped"`
+	IfDropped     uint64 `json:"if_dropped"`
+	UserDropped   uint64 `json:"user_dropped,omitempty"`
+
+	Interfaces map[string]stat.Interface `json:"interfaces,omitempty"`
+
+	FileRead uint64 `json:"file_read,omitempty"`
+	FileSize uint64 `json:"file_size,omitempty"`
+}
+
+func readRecord(path string) (*record, error) {
+	b, err := os.ReadFile(filepath.Join(path, recordFileName))
+	if err != nil {
+		return nil, errors.Wrap(err, "worker: reading record")
+	}
+
+	r := &record{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, errors.Wrap(err, "worker: decoding record")
+	}
+
+	return r, nil
+}
+
+func writeRecord(path string, r *record) error {
+	b, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "worker: encoding record")
+	}
+
+	// write to temporary file first so that crash never leaves partial record.
+	tmp := filepath.Join(path, recordFileName+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrap(err, "worker: writing record")
+	}
+
+	if err := os.Rename(tmp, filepath.Join(path, recordFileName)); err != nil {
+		return errors.Wrap(err, "worker: replacing record")
+	}
+
+	return nil
+}
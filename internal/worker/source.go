@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// Backend selects how packets are captured from devices.
+// Pcap files are always read with pcap.
+type Backend string
+
+const (
+	BackendPcap     Backend = "pcap"
+	BackendAFPacket Backend = "afpacket"
+)
+
+func (b Backend) Valid() bool {
+	switch b {
+	case BackendPcap, BackendAFPacket:
+		return true
+	}
+	return false
+}
+
+// AFPacketOptions configures the afpacket backend.
+type AFPacketOptions struct {
+	// RingSize is the size of the ring buffer of each socket in bytes.
+	// BlockTimeout is how long the kernel waits before handing
+	// partially filled block to the reader.
+	// Zero uses the defaults of gopacket/afpacket.
+	RingSize     uint64
+	BlockTimeout time.Duration
+
+	// FanoutGroup joins the sockets to the fanout group with the id
+	// if not zero. Sockets of other processes in the same group share
+	// packets with the worker.
+	FanoutGroup uint16
+	// FanoutType is one of hash, lb, cpu, rollover, random and qm.
+	// Empty means hash.
+	FanoutType string
+
+	// Sockets is the number of sockets opened for each device.
+	// More than one socket requires FanoutGroup.
+	Sockets int
+}
+
+var fanoutTypes = []string{"hash", "lb", "cpu", "rollover", "random", "qm"}
+
+// captureSource is an open capture handle of single device or file.
+type captureSource interface {
+	gopacket.PacketDataSource
+
+	LinkType() layers.LinkType
+	SetBPFFilter(expr string) error
+	// Stats returns false if the source does not support statistics.
+	Stats() (sourceStats, bool)
+	// Close makes blocked ReadPacketData return io.EOF.
+	Close()
+}
+
+type sourceStats struct {
+	KernelDropped uint64
+	IfDropped     uint64
+
+	// UserDropped is counted by the listener, not the source.
+	UserDropped uint64
+}
+
+func openSource(device string, opts *ListenOptions) (captureSource, error) {
+	switch opts.Backend {
+	case BackendAFPacket:
+		return openAFPacket(device, opts)
+	default:
+		handle, err := pcap.OpenLive(device, opts.SnapLen, opts.Promiscuous, pcap.BlockForever)
+		if err != nil {
+			return nil, err
+		}
+		return pcapSource{handle}, nil
+	}
+}
+
+func openFileSource(path string) (captureSource, error) {
+	handle, err := pcap.OpenOffline(path)
+	if err != nil {
+		return nil, err
+	}
+	return pcapSource{handle}, nil
+}
+
+type pcapSource struct{ *pcap.Handle }
+
+func (s pcapSource) Stats() (sourceStats, bool) {
+	// offline handles do not support stats.
+	stats, err := s.Handle.Stats()
+	if err != nil {
+		return sourceStats{}, false
+	}
+	return sourceStats{
+		KernelDropped: uint64(stats.PacketsDropped),
+		IfDropped:     uint64(stats.PacketsIfDropped),
+	}, true
+}
@@ -0,0 +1,187 @@
+//go:build linux
+
+package worker
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// afpacketPollTimeout bounds how long ReadPacketData blocks,
+// so that Close does not wait for the next packet.
+const afpacketPollTimeout = afpacket.OptPollTimeout(100 * time.Millisecond)
+
+var afpacketFanoutTypes = map[string]afpacket.FanoutType{
+	"":         afpacket.FanoutHash,
+	"hash":     afpacket.FanoutHash,
+	"lb":       afpacket.FanoutLoadBalance,
+	"cpu":      afpacket.FanoutCPU,
+	"rollover": afpacket.FanoutRollover,
+	"random":   afpacket.FanoutRandom,
+	"qm":       afpacket.FanoutQueueMapping,
+}
+
+func openAFPacket(device string, opts *ListenOptions) (captureSource, error) {
+	linkType, err := afpacketLinkType(device)
+	if err != nil {
+		return nil, err
+	}
+
+	afopts := []any{
+		afpacket.OptInterface(device),
+		afpacket.TPacketVersion3,
+		afpacketPollTimeout,
+	}
+
+	if opts.AFPacket.RingSize > 0 {
+		frameSize, blockSize, numBlocks, err := afpacketRing(opts.AFPacket.RingSize, int(opts.SnapLen))
+		if err != nil {
+			return nil, err
+		}
+		afopts = append(afopts,
+			afpacket.OptFrameSize(frameSize),
+			afpacket.OptBlockSize(blockSize),
+			afpacket.OptNumBlocks(numBlocks),
+		)
+	}
+
+	if opts.AFPacket.BlockTimeout > 0 {
+		afopts = append(afopts, afpacket.OptBlockTimeout(opts.AFPacket.BlockTimeout))
+	}
+
+	tp, err := afpacket.NewTPacket(afopts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "afpacket: creating socket")
+	}
+
+	if opts.AFPacket.FanoutGroup != 0 {
+		t := afpacketFanoutTypes[opts.AFPacket.FanoutType]
+		if err := tp.SetFanout(t, opts.AFPacket.FanoutGroup); err != nil {
+			tp.Close()
+			return nil, errors.Wrap(err, "afpacket: joining fanout group")
+		}
+	}
+
+	return &afpacketSource{tp: tp, snapLen: int(opts.SnapLen), linkType: linkType}, nil
+}
+
+// afpacketLinkType returns the link type of packets read from the device,
+// which afpacket hands over with the header of the device ARPHRD type.
+func afpacketLinkType(device string) (layers.LinkType, error) {
+	b, err := os.ReadFile(filepath.Join("/sys/class/net", device, "type"))
+	if err != nil {
+		return 0, errors.Wrap(err, "afpacket: reading device type")
+	}
+
+	arphrd, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, errors.Wrap(err, "afpacket: parsing device type")
+	}
+
+	switch arphrd {
+	case unix.ARPHRD_ETHER, unix.ARPHRD_LOOPBACK:
+		return layers.LinkTypeEthernet, nil
+	case unix.ARPHRD_NONE:
+		// tun devices carry bare ip packets.
+		return layers.LinkTypeRaw, nil
+	case unix.ARPHRD_IEEE80211_RADIOTAP:
+		return layers.LinkTypeIEEE80211Radio, nil
+	}
+	return 0, errors.Errorf("afpacket: unsupported device type %d", arphrd)
+}
+
+// afpacketRing computes the ring layout closest to size bytes.
+func afpacketRing(size uint64, snapLen int) (frameSize, blockSize, numBlocks int, err error) {
+	pageSize := os.Getpagesize()
+
+	if snapLen < pageSize {
+		frameSize = pageSize / (pageSize / snapLen)
+	} else {
+		frameSize = (snapLen/pageSize + 1) * pageSize
+	}
+
+	blockSize = frameSize * 128
+	numBlocks = int(size / uint64(blockSize))
+	if numBlocks == 0 {
+		return 0, 0, 0, errors.Errorf("afpacket: ring size %d is smaller than a block of %d bytes", size, blockSize)
+	}
+
+	return frameSize, blockSize, numBlocks, nil
+}
+
+type afpacketSource struct {
+	tp       *afpacket.TPacket
+	snapLen  int
+	linkType layers.LinkType
+
+	closed atomic.Bool
+	// lock is held while reading, so that the ring
+	// is never unmapped under a reader.
+	lock sync.Mutex
+}
+
+func (s *afpacketSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for !s.closed.Load() {
+		data, ci, err := s.tp.ReadPacketData()
+		if errors.Is(err, afpacket.ErrTimeout) {
+			continue
+		}
+		if err == nil && len(data) > s.snapLen {
+			data = data[:s.snapLen]
+			ci.CaptureLength = s.snapLen
+		}
+		return data, ci, err
+	}
+	return nil, gopacket.CaptureInfo{}, io.EOF
+}
+
+func (s *afpacketSource) LinkType() layers.LinkType {
+	return s.linkType
+}
+
+func (s *afpacketSource) SetBPFFilter(expr string) error {
+	insts, err := pcap.CompileBPFFilter(s.LinkType(), s.snapLen, expr)
+	if err != nil {
+		return err
+	}
+
+	raw := make([]bpf.RawInstruction, len(insts))
+	for i, inst := range insts {
+		raw[i] = bpf.RawInstruction{Op: inst.Code, Jt: inst.Jt, Jf: inst.Jf, K: inst.K}
+	}
+	return s.tp.SetBPF(raw)
+}
+
+func (s *afpacketSource) Stats() (sourceStats, bool) {
+	_, stats, err := s.tp.SocketStats()
+	if err != nil {
+		return sourceStats{}, false
+	}
+	return sourceStats{KernelDropped: uint64(stats.Drops())}, true
+}
+
+func (s *afpacketSource) Close() {
+	s.closed.Store(true)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tp.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package worker
+
+import "github.com/pkg/errors"
+
+func openAFPacket(device string, opts *ListenOptions) (captureSource, error) {
+	return nil, errors.New("afpacket: not supported on this platform")
+}
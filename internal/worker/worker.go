@@ -2,20 +2,21 @@ package worker
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket/layers"
 	"github.com/google/uuid"
 	"github.com/onee-only/netrat/internal/assembler"
 	asmfactory "github.com/onee-only/netrat/internal/assembler/factory"
-	"github.com/onee-only/netrat/internal/config"
-	"github.com/onee-only/netrat/internal/container"
 	"github.com/onee-only/netrat/internal/storage"
 	astoragefactory "github.com/onee-only/netrat/internal/storage/assemble/factory"
 	pstoragefactory "github.com/onee-only/netrat/internal/storage/packet/factory"
@@ -24,19 +25,50 @@ import (
 	"github.com/pkg/errors"
 )
 
+// Config is the daemon wide configuration shared by all workers.
+type Config struct {
+	// DataPath is the directory worker namespaces are created in.
+	DataPath string
+
+	// SnapLen is used when the worker options do not specify one.
+	SnapLen int32
+
+	PacketStreamBufSize int
+	PacketBatchSize     int
+	PacketFlushInterval time.Duration
+	AssembleTimeout     time.Duration
+}
+
+// PcapngOptions configures raw packet dump of the worker.
+type PcapngOptions = storage.PcapngOptions
+
 type WorkerOptions struct {
 	ListenOptions
 
 	AssembleTypes []assemble.AssembleType
+
+	// Pcapng makes the worker keep raw packets in pcapng files
+	// in its namespace, next to the capture database.
+	Pcapng PcapngOptions
+
+	// MaxStorageBytes stops the worker once the capture database
+	// and pcapng files grow past it. Zero means no limit.
+	MaxStorageBytes uint64
 }
 
+// recordSaveInterval is how often the record of running worker is
+// saved, so that counters survive the daemon being killed.
+const recordSaveInterval = 5 * time.Second
+
+// Validate checks the options and fills in defaults.
+// Invalid option is reported as *OptionError.
 func (o *WorkerOptions) Validate() (*WorkerOptions, error) {
 	if o == nil {
 		o = &WorkerOptions{}
 	}
 
 	if len(o.AssembleTypes) > 0 && !slices.Contains(o.CaptureLayers, layers.LayerTypeTCP) {
-		return nil, errors.New("listener: cannot use assembler when capture layer is not tcp")
+		return nil, &OptionError{Option: "AssembleTypes", Reason: "cannot use assembler when capture layer is not tcp"}
 	}
 
 	slices.Sort(o.AssembleTypes)
@@ -44,36 +76,74 @@ func (o *WorkerOptions) Validate() (*WorkerOptions, error) {
 
 	invalidAsmTypes := make([]assemble.AssembleType, 0)
 	for _, t := range o.AssembleTypes {
-		if !t.Valid() {
+		if !t.Valid() || astoragefactory.New(t) == nil {
 			invalidAsmTypes = append(invalidAsmTypes, t)
 		}
 	}
 
 	if len(invalidAsmTypes) > 0 {
-		return nil, fmt.Errorf("invalid assemble type(s): %s", invalidAsmTypes)
+		return nil, &OptionError{
+			Option: "AssembleTypes",
+			Reason: fmt.Sprintf("invalid assemble type(s): %s", invalidAsmTypes),
+		}
+	}
+
+	if o.Pcapng.MaxFiles < 0 {
+		return nil, &OptionError{Option: "Pcapng.MaxFiles", Reason: "must not be negative"}
+	}
+
+	if _, err := o.ListenOptions.Validate(); err != nil {
+		return nil, err
 	}
 
 	return o, nil
 }
 
 type Worker struct {
-	id      uuid.UUID
-	start   time.Time
-	timeout time.Duration
+	id     uuid.UUID
+	path   string
+	opts   *WorkerOptions
+	start  time.Time
+	finish time.Time
+
+	lastErr string
+	reason  stat.StopReason
+
+	state     stat.WorkerState
+	received  atomic.Uint64
+	bytes     atomic.Uint64
+	discarded atomic.Uint64
 
-	state stat.WorkerState
+	// ifaces is keyed by device name and never modified after New.
+	ifaces map[string]*ifaceCounters
+
+	// restored holds the counters of worker restored from its record.
+	restored *counters
 
 	listener   *listener
 	assemblers []assembler.Assembler
 
+	// packetClock is nil for live sources,
+	// which the assemblers use wall clock for.
+	packetClock *assembler.PacketClock
+
+	capStorage      *storage.CaptureStorage
 	packetStorage   *storage.PacketStorage
 	assembleStorage *storage.AssembleStorage
 
+	// pcapngStorage is nil if pcapng dump is disabled.
+	pcapngStorage *storage.PcapngStorage
+
 	cancel func()
+	done   chan struct{}
 	lock   sync.Mutex
 }
 
-func New(ctx context.Context, opts *WorkerOptions) (w *Worker, c context.Context, err error) {
+func New(ctx context.Context, cfg Config, opts *WorkerOptions) (w *Worker, c context.Context, err error) {
+	if opts != nil && opts.SnapLen <= 0 {
+		opts.SnapLen = cfg.SnapLen
+	}
+
 	opts, err = opts.Validate()
 	if err != nil {
 		return nil, nil, err
@@ -81,26 +151,51 @@ func New(ctx context.Context, opts *WorkerOptions) (w *Worker, c context.Context
 
 	id := uuid.New()
 
-	path, err := makeNamespace(id)
+	path, err := makeNamespace(cfg.DataPath, id)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "worker: creating namespace")
 	}
 
+	defer func() {
+		if err != nil {
+			// do not leave orphaned namespace behind.
+			os.RemoveAll(path)
+		}
+	}()
+
 	capStorage, err := storage.NewCaptureStorage(path)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "worker: creating capture storage")
 	}
 
+	defer func() {
+		if err != nil {
+			capStorage.Close()
+		}
+	}()
+
 	assembleStorage, err := storage.NewAssembleStorage(capStorage)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "worker: creating assemble storage")
 	}
 
-	packetStorage, err := storage.NewPacketStorage(capStorage)
+	packetStorage, err := storage.NewPacketStorage(capStorage, storage.PacketStorageOptions{
+		BatchSize:     cfg.PacketBatchSize,
+		FlushInterval: cfg.PacketFlushInterval,
+	})
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "worker: creating packet storage")
 	}
 
+	var pcapngStorage *storage.PcapngStorage
+	if opts.Pcapng.Enabled {
+		pcapngStorage, err = storage.NewPcapngStorage(capStorage, opts.Pcapng)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "worker: creating pcapng storage")
+		}
+		packetStorage.RegisterRaw(pcapngStorage)
+	}
+
 	for _, t := range opts.CaptureLayers {
 		s := pstoragefactory.New(t)
 		if err := packetStorage.Register(t, s); err != nil {
@@ -108,6 +203,17 @@ func New(ctx context.Context, opts *WorkerOptions) (w *Worker, c context.Context
 		}
 	}
 
+	// offline sources are timed by their packets,
+	// so results are the same however fast they are read.
+	var (
+		clock       = assembler.WallClock
+		packetClock *assembler.PacketClock
+	)
+	if opts.PcapFile != "" {
+		packetClock = new(assembler.PacketClock)
+		clock = packetClock
+	}
+
 	assemblers := make([]assembler.Assembler, len(opts.AssembleTypes))
 	for idx, t := range opts.AssembleTypes {
 		s := astoragefactory.New(t)
@@ -116,57 +222,111 @@ func New(ctx context.Context, opts *WorkerOptions) (w *Worker, c context.Context
 			return nil, nil, errors.Wrap(err, "worker: registering asm to storage")
 		}
 
-		assemblers[idx] = asmfactory.New(t, s)
+		assemblers[idx] = asmfactory.New(t, assembleStorage.ObjectStorage(t), cfg.AssembleTimeout, clock)
 	}
 
-	listener, err := newListener(&opts.ListenOptions)
-	if err != nil {
-		return nil, nil, err
+	listener := newListener(&opts.ListenOptions, cfg.PacketStreamBufSize)
+
+	ifaces := make(map[string]*ifaceCounters, len(opts.Devices))
+	for _, name := range opts.Devices {
+		ifaces[name] = &ifaceCounters{}
 	}
 
 	c, cancel := context.WithCancel(ctx)
 
 	w = &Worker{
 		id:              id,
-		timeout:         opts.Timeout,
+		path:            path,
+		opts:            opts,
 		state:           stat.WorkerStateInit,
+		ifaces:          ifaces,
 		listener:        listener,
 		assemblers:      assemblers,
+		packetClock:     packetClock,
+		capStorage:      capStorage,
 		assembleStorage: assembleStorage,
 		packetStorage:   packetStorage,
+		pcapngStorage:   pcapngStorage,
 		cancel:          cancel,
+		done:            make(chan struct{}),
 	}
 
+	if opts.MaxStorageBytes > 0 {
+		packetStorage.OnCommit(w.checkStorageSize)
+	}
+
+	w.Save()
+
 	return
 }
 
-func (w *Worker) Exec(ctx context.Context) error {
-	defer w.updateState(stat.WorkerStateFin)
+// Exec runs the worker until ctx is done, the worker is canceled
+// or the source runs out of packets.
+//
+// Packets already captured are stored even after ctx is done, and
+// the assemblers are flushed before the storages are closed.
+func (w *Worker) Exec(ctx context.Context) (err error) {
+	defer close(w.done)
+	defer func() {
+		if cerr := w.shutdown(); cerr != nil {
+			err = goerrors.Join(err, cerr)
+		}
+		w.finalize(err)
+	}()
 
 	packets, err := w.listener.listen(ctx)
 	if err != nil {
 		return err
 	}
 
-	w.state = stat.WorkerStateUp
-	w.start = time.Now()
-
-	var ok bool
-	var packet container.Packet
-	for {
-		select {
-		case <-ctx.Done():
-			return context.Cause(ctx)
-		case packet, ok = <-packets:
-			if !ok {
-				log.Println("done")
-				return nil
+	if w.pcapngStorage != nil {
+		if err := w.pcapngStorage.Open(ctx, w.pcapngInterfaces()); err != nil {
+			w.cancel()
+			for range packets {
 			}
+			return errors.Wrap(err, "worker: opening pcapng storage")
 		}
+	}
+
+	if !w.updateState(stat.WorkerStateUp, stat.WorkerStateInit) {
+		// canceled before the listener is up.
+		return nil
+	}
 
-		if err := w.packetStorage.Store(ctx, packet); err != nil {
-			w.Cancel()
-			return errors.Wrap(err, "worker: storing the packet")
+	stopSaving := w.saveEvery(recordSaveInterval)
+	defer stopSaving()
+
+	// the listener stops on ctx, but packets in the stream
+	// should still be stored.
+	storeCtx := context.WithoutCancel(ctx)
+
+	for packet := range packets {
+		w.received.Add(1)
+		w.bytes.Add(uint64(packet.Metadata().Length))
+
+		if c, ok := w.ifaces[packet.Interface]; ok {
+			c.received.Add(1)
+			c.bytes.Add(uint64(packet.Metadata().Length))
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if w.State() == stat.WorkerStatePaused {
+			w.discarded.Add(1)
+			continue
+		}
+
+		if serr := w.packetStorage.Store(storeCtx, packet); serr != nil {
+			// keep draining until the listener stops.
+			err = errors.Wrap(serr, "worker: storing the packet")
+			w.cancel()
+			continue
+		}
+
+		if w.packetClock != nil {
+			w.packetClock.Advance(packet.Metadata().Timestamp)
 		}
 
 		for _, asm := range w.assemblers {
@@ -175,60 +335,372 @@ func (w *Worker) Exec(ctx context.Context) error {
 			}
 		}
 	}
+
+	slog.Debug("worker: listener closed", "id", w.id)
+	return err
+}
+
+// shutdown flushes assemblers and closes storages.
+func (w *Worker) shutdown() error {
+	for _, asm := range w.assemblers {
+		asm.Close()
+	}
+
+	// packet storage writes the pcapng files until it is closed.
+	if err := w.packetStorage.Close(); err != nil {
+		if w.pcapngStorage != nil {
+			w.pcapngStorage.Close()
+		}
+		return errors.Wrap(err, "worker: closing storage")
+	}
+
+	if w.pcapngStorage != nil {
+		if err := w.pcapngStorage.Close(); err != nil {
+			return errors.Wrap(err, "worker: closing pcapng storage")
+		}
+	}
+	return nil
+}
+
+// checkStorageSize stops the worker once the capture storage reaches
+// MaxStorageBytes. It is called after each batch is committed.
+func (w *Worker) checkStorageSize() error {
+	size, err := w.capStorage.Size()
+	if err != nil {
+		return errors.Wrap(err, "worker: checking storage size")
+	}
+
+	if size >= w.opts.MaxStorageBytes {
+		w.stop(stat.StopReasonMaxStorageBytes)
+	}
+	return nil
+}
+
+// pcapngInterfaces returns the sources of the listener ordered by name.
+func (w *Worker) pcapngInterfaces() []storage.PcapngInterface {
+	linkTypes := w.listener.sourceLinkTypes()
+
+	names := make([]string, 0, len(linkTypes))
+	for name := range linkTypes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	ifaces := make([]storage.PcapngInterface, len(names))
+	for idx, name := range names {
+		ifaces[idx] = storage.PcapngInterface{
+			Name:     name,
+			LinkType: linkTypes[name],
+			SnapLen:  uint32(w.opts.SnapLen),
+		}
+	}
+	return ifaces
+}
+
+// stop makes the worker stop capturing for reason.
+// Only the first reason is recorded.
+func (w *Worker) stop(reason stat.StopReason) {
+	w.lock.Lock()
+	if w.reason == stat.StopReasonNone {
+		w.reason = reason
+	}
+	w.lock.Unlock()
+
+	w.cancel()
+}
+
+// finalize records the final state of the worker.
+// Worker is marked as failed if err is not nil.
+func (w *Worker) finalize(err error) {
+	w.lock.Lock()
+	if w.reason == stat.StopReasonNone {
+		w.reason = w.listener.stopReason()
+	}
+	w.lock.Unlock()
+
+	if err != nil {
+		w.lock.Lock()
+		w.lastErr = err.Error()
+		w.lock.Unlock()
+
+		w.updateState(stat.WorkerStateFailed,
+			stat.WorkerStateInit, stat.WorkerStateUp,
+			stat.WorkerStatePaused, stat.WorkerStateCancel,
+		)
+	} else {
+		w.updateState(stat.WorkerStateFin, stat.WorkerStateUp, stat.WorkerStatePaused)
+	}
+
+	// save final counters.
+	w.Save()
 }
 
-func (w *Worker) Cancel() {
-	if w.updateState(stat.WorkerStateCancel) {
-		w.cancel()
+// Cancel stops the worker if it is not finished yet.
+func (w *Worker) Cancel() (canceled bool) {
+	if w.updateState(stat.WorkerStateCancel, stat.WorkerStateInit, stat.WorkerStateUp, stat.WorkerStatePaused) {
+		w.stop(stat.StopReasonCanceled)
+		return true
 	}
+	return false
+}
+
+// Pause makes the worker discard captured packets until Resume is called.
+// The capture handle stays open while paused.
+func (w *Worker) Pause() (paused bool) {
+	return w.updateState(stat.WorkerStatePaused, stat.WorkerStateUp)
+}
+
+// Resume makes the paused worker store captured packets again.
+func (w *Worker) Resume() (resumed bool) {
+	return w.updateState(stat.WorkerStateUp, stat.WorkerStatePaused)
+}
+
+// Done returns a channel that is closed when Exec returns.
+func (w *Worker) Done() <-chan struct{} {
+	return w.done
 }
 
 func (w *Worker) ID() uuid.UUID {
 	return w.id
 }
 
-func (w *Worker) updateState(s stat.WorkerState) (changed bool) {
+// Path returns the namespace directory of the worker.
+func (w *Worker) Path() string {
+	return w.path
+}
+
+func (w *Worker) State() stat.WorkerState {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
-	if w.state == stat.WorkerStateUp {
-		w.state = s
-		return true
+	return w.state
+}
+
+// updateState changes state to s if current state is one of from.
+func (w *Worker) updateState(s stat.WorkerState, from ...stat.WorkerState) (changed bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !slices.Contains(from, w.state) {
+		return false
+	}
+
+	w.state = s
+
+	switch s {
+	case stat.WorkerStateUp:
+		if w.start.IsZero() {
+			w.start = time.Now()
+		}
+	case stat.WorkerStateFin, stat.WorkerStateCancel, stat.WorkerStateFailed:
+		w.finish = time.Now()
+	}
+
+	w.save()
+	return true
+}
+
+// saveEvery saves the record every interval until the returned
+// function is called. The function waits for the last save to finish.
+func (w *Worker) saveEvery(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				w.Save()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
 	}
-	return false
+}
+
+// Save persists the record of the worker with current counters.
+func (w *Worker) Save() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.save()
+}
+
+// save persists the record of the worker. It must be called with lock held.
+func (w *Worker) save() {
+	r := &record{
+		ID:         w.id,
+		Options:    *w.opts,
+		State:      w.state,
+		CreatedAt:  w.start,
+		FinishedAt: w.finish,
+		LastError:  w.lastErr,
+		StopReason: w.reason,
+		Counters:   w.counters(),
+	}
+
+	if err := writeRecord(w.path, r); err != nil {
+		slog.Warn("worker: saving record", "id", w.id, "err", err)
+	}
+}
+
+func (w *Worker) counters() counters {
+	if w.restored != nil {
+		return *w.restored
+	}
+
+	c := counters{
+		Received:  w.received.Load(),
+		Bytes:     w.bytes.Load(),
+		Discarded: w.discarded.Load(),
+		Stored:    w.packetStorage.Stored(),
+		Assembled: w.assembleStorage.Stored(),
+	}
+
+	if p := w.listener.fileProgress(); p != nil {
+		c.FileRead, c.FileSize, _ = p.state()
+	}
+
+	stats := w.listener.stats()
+
+	if len(w.ifaces) > 0 {
+		c.Interfaces = make(map[string]stat.Interface, len(w.ifaces))
+	}
+	for name, ic := range w.ifaces {
+		s := stats[name]
+		c.Interfaces[name] = stat.Interface{
+			Received:      ic.received.Load(),
+			Bytes:         ic.bytes.Load(),
+			KernelDropped: s.KernelDropped,
+			IfDropped:     s.IfDropped,
+			UserDropped:   s.UserDropped,
+		}
+	}
+
+	for _, s := range stats {
+		c.KernelDropped += s.KernelDropped
+		c.IfDropped += s.IfDropped
+		c.UserDropped += s.UserDropped
+	}
+
+	return c
+}
+
+type ifaceCounters struct {
+	received atomic.Uint64
+	bytes    atomic.Uint64
 }
 
 func (w *Worker) ExportStats() stat.Worker {
-	stat := stat.Worker{
-		ID:        w.id,
-		CreatedAt: w.start,
-		Timeout:   w.timeout,
-		State:     w.state,
+	w.lock.Lock()
+	state, start, finish, lastErr, reason := w.state, w.start, w.finish, w.lastErr, w.reason
+	w.lock.Unlock()
+
+	c := w.counters()
 
-		SnapLen:     w.listener.opts.SnapLen,
-		Promiscuous: w.listener.opts.Promiscuous,
-		Captures:    w.listener.opts.CaptureLayers,
-		BPFFilter:   w.listener.opts.BPFFilter,
+	var eta time.Duration
+	if p := w.listener.fileProgress(); p != nil && (state == stat.WorkerStateUp || state == stat.WorkerStatePaused) {
+		_, _, eta = p.state()
+	}
+
+	stat := stat.Worker{
+		ID:         w.id,
+		CreatedAt:  start,
+		FinishedAt: finish,
+		Timeout:    w.opts.Timeout,
+		State:      state,
+		LastError:  lastErr,
+		StopReason: reason,
+
+		MaxPackets:      w.opts.MaxPackets,
+		MaxBytes:        w.opts.MaxBytes,
+		MaxStorageBytes: w.opts.MaxStorageBytes,
+
+		Received:  c.Received,
+		Bytes:     c.Bytes,
+		Discarded: c.Discarded,
+		Stored:    c.Stored,
+		Assembled: c.Assembled,
+
+		KernelDropped: c.KernelDropped,
+		IfDropped:     c.IfDropped,
+		UserDropped:   c.UserDropped,
+		Interfaces:    c.Interfaces,
+
+		FileRead: c.FileRead,
+		FileSize: c.FileSize,
+		ETA:      eta,
+
+		SnapLen:     w.opts.SnapLen,
+		Promiscuous: w.opts.Promiscuous,
+		Captures:    w.opts.CaptureLayers,
+		Assembles:   w.opts.AssembleTypes,
+		BPFFilter:   w.opts.BPFFilter,
 	}
 
-	if w.listener.opts.Device != "" {
+	if len(w.opts.Devices) > 0 {
 		stat.Live = true
-		stat.Src = w.listener.opts.Device
+		stat.Src = strings.Join(w.opts.Devices, ",")
+		stat.Backend = string(w.opts.Backend)
+		stat.DropPolicy = string(w.opts.DropPolicy)
 	} else {
-		stat.Src = w.listener.opts.PcapFile
+		stat.Src = w.opts.PcapFile
 	}
 
-	assembles := make([]assemble.AssembleType, 0, len(w.assemblers))
-	for _, asm := range w.assemblers {
-		assembles = append(assembles, asm.Type())
+	return stat
+}
+
+// Restore loads the worker persisted in namespace at path.
+// Restored worker only reports its stats and data, it never runs again.
+// Worker that was still running when the record was last written is
+// marked as interrupted.
+func Restore(path string) (*Worker, error) {
+	r, err := readRecord(path)
+	if err != nil {
+		return nil, err
 	}
 
-	stat.Assembles = assembles
+	done := make(chan struct{})
+	close(done)
+
+	w := &Worker{
+		id:       r.ID,
+		path:     path,
+		opts:     &r.Options,
+		start:    r.CreatedAt,
+		finish:   r.FinishedAt,
+		lastErr:  r.LastError,
+		reason:   r.StopReason,
+		state:    r.State,
+		restored: &r.Counters,
+		listener: newListener(&r.Options.ListenOptions, 0),
+		cancel:   func() {},
+		done:     done,
+	}
 
-	return stat
+	switch w.state {
+	case stat.WorkerStateInit, stat.WorkerStateUp, stat.WorkerStatePaused:
+		w.lock.Lock()
+		w.state = stat.WorkerStateInterrupted
+		w.save()
+		w.lock.Unlock()
+	}
+
+	return w, nil
 }
 
-func makeNamespace(id uuid.UUID) (string, error) {
-	path := filepath.Join(config.DefaultDataPath, id.String())
+func makeNamespace(base string, id uuid.UUID) (string, error) {
+	path := filepath.Join(base, id.String())
 	return path, os.MkdirAll(path, 0644)
 }
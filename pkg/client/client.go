@@ -0,0 +1,295 @@
+// Package client provides a Go client for the netratd control socket.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/onee-only/netrat/pkg/device"
+	"github.com/onee-only/netrat/pkg/msg"
+	"github.com/onee-only/netrat/pkg/stat"
+	"github.com/pkg/errors"
+)
+
+type (
+	WorkerOptions   = msg.WorkerOptions
+	ListenOptions   = msg.ListenOptions
+	AFPacketOptions = msg.AFPacketOptions
+	PcapngOptions   = msg.PcapngOptions
+
+	ExportOptions = msg.ExportOptions
+	ExportFilter  = msg.ExportFilter
+	ExportFormat  = msg.ExportFormat
+)
+
+const (
+	ExportFormatPcap   = msg.ExportFormatPcap
+	ExportFormatPcapng = msg.ExportFormatPcapng
+)
+
+// UnexpectedPayloadError is returned when netratd answers with payload
+// of unexpected type, such as netratd of a different version.
+type UnexpectedPayloadError struct {
+	Payload any
+}
+
+func (e *UnexpectedPayloadError) Error() string {
+	return fmt.Sprintf("client: unexpected payload %T", e.Payload)
+}
+
+// Client is a connection to netratd.
+// It is safe for concurrent use, but requests are sent one at a time.
+type Client struct {
+	conn net.Conn
+
+	lenBuf []byte
+	buf    *bytes.Buffer
+
+	lock sync.Mutex
+}
+
+// Dial connects to netratd listening on given unix socket address.
+func Dial(ctx context.Context, addr string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "client: dialing netratd")
+	}
+
+	return &Client{
+		conn:   conn,
+		lenBuf: make([]byte, 4),
+		buf:    new(bytes.Buffer),
+	}, nil
+}
+
+// Close closes the underlying connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Listen starts a new worker with opts and returns its id.
+func (c *Client) Listen(ctx context.Context, opts WorkerOptions) (uuid.UUID, error) {
+	res, err := c.Do(ctx, &msg.Request{
+		Type:    msg.RequestTypeListen,
+		Payload: msg.WorkerInitPayload{Opts: opts},
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	p, ok := res.Payload.(msg.WorkerIDPayload)
+	if !ok {
+		return uuid.Nil, &UnexpectedPayloadError{Payload: res.Payload}
+	}
+	return p.ID, nil
+}
+
+// Workers returns stats of all workers.
+func (c *Client) Workers(ctx context.Context) ([]stat.Worker, error) {
+	res, err := c.Do(ctx, &msg.Request{
+		Type:    msg.RequestTypeWorkerList,
+		Payload: msg.EmptyPayload{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	p, ok := res.Payload.(msg.WorkerListPayload)
+	if !ok {
+		return nil, &UnexpectedPayloadError{Payload: res.Payload}
+	}
+	return p.Workers, nil
+}
+
+// Stat returns stat of the worker with given id.
+func (c *Client) Stat(ctx context.Context, id uuid.UUID) (stat.Worker, error) {
+	res, err := c.Do(ctx, &msg.Request{
+		Type:    msg.RequestTypeWorkerStat,
+		Payload: msg.WorkerIDPayload{ID: id},
+	})
+	if err != nil {
+		return stat.Worker{}, err
+	}
+	p, ok := res.Payload.(msg.WorkerStatPayload)
+	if !ok {
+		return stat.Worker{}, &UnexpectedPayloadError{Payload: res.Payload}
+	}
+	return p.Stat, nil
+}
+
+// Cancel stops the running worker with given id.
+func (c *Client) Cancel(ctx context.Context, id uuid.UUID) error {
+	_, err := c.Do(ctx, &msg.Request{
+		Type:    msg.RequestTypeWorkerCancel,
+		Payload: msg.WorkerIDPayload{ID: id},
+	})
+	return err
+}
+
+// Pause makes the running worker with given id discard packets
+// until Resume is called.
+func (c *Client) Pause(ctx context.Context, id uuid.UUID) error {
+	_, err := c.Do(ctx, &msg.Request{
+		Type:    msg.RequestTypeWorkerPause,
+		Payload: msg.WorkerIDPayload{ID: id},
+	})
+	return err
+}
+
+// Resume makes the paused worker with given id store packets again.
+func (c *Client) Resume(ctx context.Context, id uuid.UUID) error {
+	_, err := c.Do(ctx, &msg.Request{
+		Type:    msg.RequestTypeWorkerResume,
+		Payload: msg.WorkerIDPayload{ID: id},
+	})
+	return err
+}
+
+// Delete stops the worker with given id and removes it from netratd.
+// Captured data of the worker is removed too, unless keepData is set.
+func (c *Client) Delete(ctx context.Context, id uuid.UUID, keepData bool) error {
+	_, err := c.Do(ctx, &msg.Request{
+		Type:    msg.RequestTypeWorkerDelete,
+		Payload: msg.WorkerDeletePayload{ID: id, KeepData: keepData},
+	})
+	return err
+}
+
+// Devices returns devices netratd can capture from.
+func (c *Client) Devices(ctx context.Context) ([]device.Device, error) {
+	res, err := c.Do(ctx, &msg.Request{
+		Type:    msg.RequestTypeDevices,
+		Payload: msg.EmptyPayload{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	p, ok := res.Payload.(msg.DeviceListPayload)
+	if !ok {
+		return nil, &UnexpectedPayloadError{Payload: res.Payload}
+	}
+	return p.Devices, nil
+}
+
+// Export writes packets of the worker with given id to w as
+// opts.Format and returns the number of packets exported.
+// If opts.Path is set, netratd writes the file instead and w is not used.
+func (c *Client) Export(ctx context.Context, id uuid.UUID, opts ExportOptions, w io.Writer) (uint64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.Close()
+	})
+	defer stop()
+
+	err := c.write(&msg.Request{
+		Type:    msg.RequestTypeExport,
+		Payload: msg.ExportPayload{ID: id, Opts: opts},
+	})
+
+	for err == nil {
+		var res *msg.Response
+		if res, err = c.read(); err != nil {
+			break
+		}
+		if err = res.Err(); err != nil {
+			return 0, err
+		}
+
+		switch p := res.Payload.(type) {
+		case msg.ExportChunkPayload:
+			if _, err := w.Write(p.Data); err != nil {
+				// rest of the responses cannot be skipped.
+				c.conn.Close()
+				return 0, errors.Wrap(err, "client: writing export")
+			}
+		case msg.ExportDonePayload:
+			return p.Packets, nil
+		default:
+			err = &UnexpectedPayloadError{Payload: p}
+		}
+	}
+
+	if ctxErr := context.Cause(ctx); ctxErr != nil {
+		return 0, ctxErr
+	}
+	return 0, err
+}
+
+// Do sends req and waits for its response.
+// Error carried by the response is returned as error.
+//
+// If ctx is done before the response arrives, the connection is closed
+// and the client can no longer be used.
+func (c *Client) Do(ctx context.Context, req *msg.Request) (*msg.Response, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stop := context.AfterFunc(ctx, func() {
+		// unblock pending read or write.
+		c.conn.Close()
+	})
+	defer stop()
+
+	res, err := c.roundTrip(req)
+	if err != nil {
+		if ctxErr := context.Cause(ctx); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) roundTrip(req *msg.Request) (*msg.Response, error) {
+	if err := c.write(req); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func (c *Client) write(req *msg.Request) error {
+	c.buf.Reset()
+	if err := req.Encode(c.buf); err != nil {
+		return errors.Wrap(err, "client: encoding request")
+	}
+
+	binary.LittleEndian.PutUint32(c.lenBuf, uint32(c.buf.Len()))
+	if _, err := c.conn.Write(c.lenBuf); err != nil {
+		return errors.Wrap(err, "client: writing len data")
+	}
+	if _, err := io.Copy(c.conn, c.buf); err != nil {
+		return errors.Wrap(err, "client: writing payload")
+	}
+	return nil
+}
+
+func (c *Client) read() (*msg.Response, error) {
+	if _, err := io.ReadFull(c.conn, c.lenBuf); err != nil {
+		return nil, errors.Wrap(err, "client: reading len data")
+	}
+
+	c.buf.Reset()
+	length := int64(binary.LittleEndian.Uint32(c.lenBuf))
+	if _, err := io.CopyN(c.buf, c.conn, length); err != nil {
+		return nil, errors.Wrap(err, "client: reading payload")
+	}
+
+	res, err := msg.DecodeResponse(c.buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "client: decoding response")
+	}
+
+	return res, nil
+}
@@ -1,6 +1,37 @@
+// Package device describes network interfaces netratd captures from.
 package device
 
+import "net"
+
 const (
 	// LoopBack represents loopback device name of machine.
 	LoopBack = loopBackDevice
 )
+
+// Device describes a network interface packets can be captured from.
+type Device struct {
+	Name        string
+	Description string
+	Addresses   []Address
+
+	Up       bool
+	Loopback bool
+	Running  bool
+	Wireless bool
+
+	// LinkTypes lists the data link types supported by the device.
+	// It is empty when the device could not be opened.
+	LinkTypes []LinkType
+}
+
+type Address struct {
+	IP        net.IP
+	Netmask   net.IPMask
+	Broadaddr net.IP
+	P2P       net.IP
+}
+
+type LinkType struct {
+	Name        string
+	Description string
+}
@@ -0,0 +1,114 @@
+package msg
+
+import (
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/uuid"
+	"github.com/onee-only/netrat/pkg/assemble"
+)
+
+// WorkerOptions is the wire form of the options a worker is started with.
+// The daemon validates them, see netrat listen for the meaning of each.
+type WorkerOptions struct {
+	ListenOptions
+
+	AssembleTypes []assemble.AssembleType
+
+	Pcapng PcapngOptions
+
+	// MaxStorageBytes stops the worker once the capture database
+	// and pcapng files grow past it. Zero means no limit.
+	MaxStorageBytes uint64
+}
+
+type ListenOptions struct {
+	// Devices are captured at once and merged by timestamp.
+	// PcapFile is read when no device is given.
+	Devices  []string
+	PcapFile string
+
+	SnapLen     int32
+	Promiscuous bool
+	BPFFilter   string
+
+	CaptureLayers []gopacket.LayerType
+
+	// Timeout, MaxPackets and MaxBytes stop the worker
+	// when reached. Zero means no limit.
+	Timeout    time.Duration
+	MaxPackets uint64
+	MaxBytes   uint64
+
+	// Backend is either pcap or afpacket. Empty means pcap.
+	Backend  string
+	AFPacket AFPacketOptions
+
+	// ReplaySpeed paces packets of PcapFile by their capture
+	// timestamps, multiplied by the speed. Zero reads as fast as possible.
+	ReplaySpeed float64
+
+	// BufferSize is the number of captured packets waiting to be stored.
+	// Zero uses the size configured for netratd.
+	BufferSize int
+	// DropPolicy is one of block, drop-newest and drop-oldest.
+	// Empty means block.
+	DropPolicy string
+}
+
+type AFPacketOptions struct {
+	RingSize     uint64
+	BlockTimeout time.Duration
+
+	FanoutGroup uint16
+	FanoutType  string
+
+	Sockets int
+}
+
+type PcapngOptions struct {
+	Enabled bool
+
+	RotateSize     uint64
+	RotateInterval time.Duration
+
+	MaxFiles int
+}
+
+type ExportFormat string
+
+const (
+	ExportFormatPcap   ExportFormat = "pcap"
+	ExportFormatPcapng ExportFormat = "pcapng"
+)
+
+type ExportOptions struct {
+	Format ExportFormat
+
+	// Path is the file the server writes the export to. It must be inside
+	// the export directory of the server, and relative path is taken from it.
+	// Existing file is not overwritten.
+	// If empty, the export is streamed back to the client.
+	Path string
+
+	Filter ExportFilter
+}
+
+// ExportFilter selects packets to export. Zero value fields match every packet.
+type ExportFilter struct {
+	// Start and End limit the capture timestamp to [Start, End).
+	Start, End time.Time
+
+	SrcIP, DstIP     net.IP
+	SrcPort, DstPort uint16
+	// Transport is either layers.LayerTypeTCP or layers.LayerTypeUDP.
+	Transport gopacket.LayerType
+
+	// Layers are the layers every exported packet must have.
+	Layers []gopacket.LayerType
+
+	// HTTPStreamID selects packets of the http stream
+	// with given id, in both directions.
+	HTTPStreamID uuid.UUID
+}
@@ -4,7 +4,7 @@ import (
 	"encoding/gob"
 	"io"
 
-	"github.com/onee-only/netrat/internal/worker"
+	"github.com/google/uuid"
 )
 
 type RequestType uint8
@@ -13,6 +13,12 @@ const (
 	RequestTypeListen RequestType = 1 + iota
 	RequestTypeWorkerList
 	RequestTypeWorkerStat
+	RequestTypeWorkerCancel
+	RequestTypeWorkerDelete
+	RequestTypeWorkerPause
+	RequestTypeWorkerResume
+	RequestTypeDevices
+	RequestTypeExport
 )
 
 type Request struct {
@@ -32,9 +38,23 @@ func DecodeRequest(r io.Reader) (req *Request, err error) {
 }
 
 type WorkerInitPayload struct {
-	Opts worker.WorkerOptions
+	Opts WorkerOptions
+}
+
+type WorkerDeletePayload struct {
+	ID       uuid.UUID
+	KeepData bool
+}
+
+// ExportPayload is answered with ExportChunkPayload responses
+// followed by ExportDonePayload. Chunks are not sent if Opts.Path is set.
+type ExportPayload struct {
+	ID   uuid.UUID
+	Opts ExportOptions
 }
 
 func registerRequest() {
 	gob.Register(WorkerInitPayload{})
-}
\ No newline at end of file
+	gob.Register(WorkerDeletePayload{})
+	gob.Register(ExportPayload{})
+}
@@ -0,0 +1,108 @@
+package msg
+
+import (
+	"encoding/gob"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/onee-only/netrat/pkg/device"
+	"github.com/onee-only/netrat/pkg/stat"
+)
+
+type ErrCode uint8
+
+const (
+	ErrCodeUnknown ErrCode = iota
+	// ErrCodeInvalidOption is set when the request has invalid option.
+	// Error.Option holds the name of the option.
+	ErrCodeInvalidOption
+)
+
+// Error is the error returned by the server.
+type Error struct {
+	Code   ErrCode
+	Option string
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+type Response struct {
+	Payload any
+	ErrMsg  string
+
+	ErrCode   ErrCode
+	ErrOption string
+}
+
+// Err returns *Error if the response is an error.
+func (r *Response) Err() error {
+	if r.ErrMsg == "" {
+		return nil
+	}
+	return &Error{
+		Code:   r.ErrCode,
+		Option: r.ErrOption,
+		Msg:    r.ErrMsg,
+	}
+}
+
+func (r *Response) Encode(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(r)
+}
+
+func DecodeResponse(r io.Reader) (res *Response, err error) {
+	res = &Response{}
+	err = gob.NewDecoder(r).Decode(res)
+	return
+}
+
+func NewErrResponse(err error) *Response {
+	return &Response{
+		Payload: EmptyPayload{},
+		ErrMsg:  err.Error(),
+	}
+}
+
+// NewOptionErrResponse reports that option of the request is invalid.
+func NewOptionErrResponse(option string, err error) *Response {
+	res := NewErrResponse(err)
+	res.ErrCode = ErrCodeInvalidOption
+	res.ErrOption = option
+	return res
+}
+
+type WorkerIDPayload struct {
+	ID uuid.UUID
+}
+
+type WorkerListPayload struct {
+	Workers []stat.Worker
+}
+
+type WorkerStatPayload struct {
+	Stat stat.Worker
+}
+
+type DeviceListPayload struct {
+	Devices []device.Device
+}
+
+type ExportChunkPayload struct {
+	Data []byte
+}
+
+type ExportDonePayload struct {
+	Packets uint64
+}
+
+func registerResponse() {
+	gob.Register(WorkerIDPayload{})
+	gob.Register(WorkerListPayload{})
+	gob.Register(WorkerStatPayload{})
+	gob.Register(DeviceListPayload{})
+	gob.Register(ExportChunkPayload{})
+	gob.Register(ExportDonePayload{})
+}
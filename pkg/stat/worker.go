@@ -15,13 +15,72 @@ const (
 	WorkerStateUp
 	WorkerStateFin
 	WorkerStateCancel
+	WorkerStatePaused
+	WorkerStateInterrupted
+	WorkerStateFailed
 )
 
+func (s WorkerState) String() string {
+	switch s {
+	case WorkerStateInit:
+		return "init"
+	case WorkerStateUp:
+		return "up"
+	case WorkerStateFin:
+		return "finished"
+	case WorkerStateCancel:
+		return "canceled"
+	case WorkerStatePaused:
+		return "paused"
+	case WorkerStateInterrupted:
+		return "interrupted"
+	case WorkerStateFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// StopReason describes why the worker stopped capturing.
+type StopReason uint8
+
+const (
+	StopReasonNone StopReason = iota
+	StopReasonEndOfSource
+	StopReasonTimeout
+	StopReasonMaxPackets
+	StopReasonMaxBytes
+	StopReasonMaxStorageBytes
+	StopReasonCanceled
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopReasonNone:
+		return "none"
+	case StopReasonEndOfSource:
+		return "end of source"
+	case StopReasonTimeout:
+		return "timeout"
+	case StopReasonMaxPackets:
+		return "packet limit"
+	case StopReasonMaxBytes:
+		return "byte limit"
+	case StopReasonMaxStorageBytes:
+		return "storage limit"
+	case StopReasonCanceled:
+		return "canceled"
+	}
+	return "unknown"
+}
+
 type Worker struct {
 	ID uuid.UUID
 
-	Live bool
-	Src  string
+	Live    bool
+	Src     string
+	Backend string
+	// DropPolicy is empty for offline sources.
+	DropPolicy string
 
 	SnapLen     int32
 	Promiscuous bool
@@ -30,7 +89,56 @@ type Worker struct {
 	Captures  []gopacket.LayerType
 	Assembles []assemble.AssembleType
 
-	State     WorkerState
-	CreatedAt time.Time
-	Timeout   time.Duration
+	State      WorkerState
+	CreatedAt  time.Time
+	FinishedAt time.Time
+	Timeout    time.Duration
+
+	MaxPackets      uint64
+	MaxBytes        uint64
+	MaxStorageBytes uint64
+
+	StopReason StopReason
+
+	// LastError describes why the worker failed.
+	LastError string
+
+	// Received is the number of packets handed to the worker
+	// and Bytes is the sum of their wire length.
+	Received uint64
+	Bytes    uint64
+
+	// Discarded is the number of packets dropped while paused.
+	Discarded uint64
+
+	Stored    map[gopacket.LayerType]uint64
+	Assembled map[assemble.AssembleType]uint64
+
+	// KernelDropped and IfDropped are drop counters reported by pcap.
+	// UserDropped is the number of packets dropped by the drop policy
+	// because the worker could not store them fast enough.
+	// They are always zero for offline sources.
+	KernelDropped uint64
+	IfDropped     uint64
+	UserDropped   uint64
+
+	// FileRead is the estimated number of bytes read out of FileSize
+	// and ETA is the estimated time left, for offline sources.
+	FileRead uint64
+	FileSize uint64
+	ETA      time.Duration
+
+	// Interfaces holds the counters of each captured device.
+	// It is empty for offline sources.
+	Interfaces map[string]Interface
+}
+
+// Interface is the counters of single captured device.
+type Interface struct {
+	Received uint64
+	Bytes    uint64
+
+	KernelDropped uint64
+	IfDropped     uint64
+	UserDropped   uint64
 }